@@ -10,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"time"
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
@@ -22,16 +23,24 @@ import (
 // To store this data the key will be: md5_hash(cert)
 type OrgRegistering struct {
 	OperationType	string	`json:"operationType"` //operationType is used to distinguish the various types of operations(DataRegister)
+	SchemaVersion	int		`json:"schemaVersion"` //schemaVersion is the version of this schema when the record was written, see currentSchemaVersion
 	Owner 			string 	`json:"owner"`   //owner is the md5 hash value of cert
 	OrgName     	string 	`json:"orgName"` //organization name from subject of cert
 	CommonName		string 	`json:"commonName"` //common name from subject of cert
 	Timestamp   	pb_timestamp.Timestamp   `json:"timestamp"` //the time when the action happens
+	Status			string	`json:"status"` //one of: active; suspended; deregistered
+	StatusReason	string	`json:"statusReason"` //the reason given by admin when the status changed
+	StatusUpdatedBy	string	`json:"statusUpdatedBy"` //the proposal which changed the status, like Propose_<txID>
+	StatusTimestamp	pb_timestamp.Timestamp   `json:"statusTimestamp"` //the time when the status changed
+	PublicKey		string	`json:"publicKey"` //PEM of the public key of cert, the sketches sent to this owner are encrypted with it
+	UpdatedBy		string	`json:"updatedBy,omitempty"` //ownerId of the identity which wrote this value, see GetRecordHistory
 }
 
 // Data registering schema is used for uploading a new file.
 // To store this data the key will be: md5_hash(cert) + "_" + dataName
 type DataRegistering struct {
 	OperationType	string	`json:"operationType"` //operationType is used to distinguish the various types of operations(DataRegister)
+	SchemaVersion	int		`json:"schemaVersion"` //schemaVersion is the version of this schema when the record was written, see currentSchemaVersion
 	DataType 		string 	`json:"dataType"`   //dataType is used to distinguish the various types of files(the key is phone number or imei etc.)
 	Owner      		string 	`json:"owner"`    //owner is the md5 hash value of cert
 	DataName       	string 	`json:"dataName"`
@@ -43,12 +52,20 @@ type DataRegistering struct {
 	Timestamp   	pb_timestamp.Timestamp   `json:"timestamp"` //the time when the action happens
 	MatchCount		int		`json:"matchCount"`		//how many times the data has ever been matched before.
 	LastMatchTimestamp	pb_timestamp.Timestamp   `json:"lastMatchTimestamp"` //the time when the data participated matching before.
+	ExpiryTimestamp	pb_timestamp.Timestamp   `json:"expiryTimestamp"` //the data can not be matched after this time, 0 means never expires.
+	IsExpired		bool	`json:"isExpired"` //set by SweepExpired after the expiry has passed.
+	EncryptedHLL	*EncryptedPayload	`json:"encryptedHLL,omitempty"` //HLL sent in the transient map, only the recipient can decrypt it
+	EncryptedBloom	*EncryptedPayload	`json:"encryptedBloom,omitempty"` //Bloom sent in the transient map, only the recipient can decrypt it
+	HLLArtifact		*ArtifactRef	`json:"hllArtifact,omitempty"` //HLL kept off-chain, bound by its content hash
+	BloomArtifact	*ArtifactRef	`json:"bloomArtifact,omitempty"` //Bloom kept off-chain, bound by its content hash
+	UpdatedBy		string	`json:"updatedBy,omitempty"` //ownerId of the identity which wrote this value, see GetRecordHistory
 }
 
 // On boarding schema is used for matching.
 // To store this data the key will be: TxID + "_" + Step
 type OnBoarding struct {
 	OperationType	string	`json:"operationType"` //operationType is used to distinguish the various types of operations(OnBoarding)
+	SchemaVersion	int		`json:"schemaVersion"` //schemaVersion is the version of this schema when the record was written, see currentSchemaVersion
 	TxID			string  `json:"txID"`	  //txID of step 1 to track like sessionId for one matching
 	Step 			int 	`json:"step"`
 	Owner      		string 	`json:"owner"`    //owner is the md5 hash value of cert
@@ -58,8 +75,25 @@ type OnBoarding struct {
 	TargetOwner     string 	`json:"targetOwner"`
 	TargetDataName  string  `json:"targetDataName"`
 	IsFinished		bool 	`json:"isFinished"`
-	//BloomURI		string 	`json:"bloomURI"`
 	Timestamp   	pb_timestamp.Timestamp   `json:"timestamp"` //the time when the action happens
+	Flags			[]string	`json:"flags,omitempty"` //set when one of the parties was suspended or deregistered while the matching is in-flight
+	EncryptedBloom	*EncryptedPayload	`json:"encryptedBloom,omitempty"` //Bloom sent in the transient map, encrypted to the counterparty
+	BloomArtifact	*ArtifactRef	`json:"bloomArtifact,omitempty"` //Bloom of this step kept off-chain, bound by its content hash
+	ActingParty		string	`json:"actingParty"` //ownerId of the party which submitted this step, verified against the cert
+	Attestation		*StepAttestation	`json:"attestation,omitempty"` //countersignature of the other party, see OnBoardingAttest
+	Dispute			*StepDispute	`json:"dispute,omitempty"` //raised by the other party instead of countersigning, see OnBoardingDispute
+	StartTimestamp	pb_timestamp.Timestamp   `json:"startTimestamp"` //the time of step 1, used for the reputation of both parties
+	IsAbandoned		bool	`json:"isAbandoned"` //set by SweepAbandoned when no step happened for a long time
+	UpdatedBy		string	`json:"updatedBy,omitempty"` //ownerId of the identity which wrote this value, see GetRecordHistory
+}
+
+// Identity schema is returned by WhoAmI, it is not stored on chain.
+type Identity struct {
+	Owner			string	`json:"owner"`   //owner is the md5 hash value of cert
+	OrgName			string	`json:"orgName"` //organization name from subject of cert
+	CommonName		string	`json:"commonName"` //common name from subject of cert
+	Roles			[]string	`json:"roles"` //roles from OU or fabric-ca attributes of cert
+	Registered		json.RawMessage	`json:"registered,omitempty"` //the OrgRegister records, omitted if not registered yet
 }
 
 type QueryResult_DataRegistering struct {
@@ -72,6 +106,7 @@ type QueryResult_DataRegistering_Array []*QueryResult_DataRegistering
 // New schemas used for panel
 type Paneling struct {
 	OperationType	string	`json:"operationType"` //operationType is used to distinguish the various types of operations(Paneling)
+	SchemaVersion	int		`json:"schemaVersion"` //schemaVersion is the version of this schema when the record was written, see currentSchemaVersion
 	TxID			string  `json:"txID"`	  //txID is used to tracking all the progress of panel
 	Sponsor  		string	`json:"sponsor"`    //sponsor is the ownerId which is the md5 hash value of cert
 	DataType 		string 	`json:"dataType"`   //dataType is used to distinguish the various types of files(the key is phone number or imei etc.)
@@ -80,6 +115,8 @@ type Paneling struct {
 	IsFinished		bool 	`json:"isFinished"`
 	Timestamp   	pb_timestamp.Timestamp   `json:"timestamp"` //the time when the action happens
 	LastUpdatedTimestamp	pb_timestamp.Timestamp   `json:"lastUpdatedTimestamp"` //the time when the data updated.
+	Flags			[]string	`json:"flags,omitempty"` //set when the sponsor or one of the providers was suspended or deregistered while the panel is in-flight
+	UpdatedBy		string	`json:"updatedBy,omitempty"` //ownerId of the identity which wrote this value, see GetRecordHistory
 }
 
 type Providers struct {
@@ -100,6 +137,8 @@ type Gender struct {
 type DataDigest struct {
 	LineCount      	int 	`json:"lineCount"`
 	HLL				string	`json:"hll"`
+	EncryptedHLL	*EncryptedPayload	`json:"encryptedHLL,omitempty"` //HLL sent in the transient map, encrypted to the sponsor
+	HLLArtifact		*ArtifactRef	`json:"hllArtifact,omitempty"` //HLL kept off-chain, bound by its content hash
 	//Bloom			string	`json:"bloom"`  //not used for now
 }
 
@@ -107,8 +146,65 @@ type DataDigest struct {
 type AdChainChaincode struct {
 }
 
-// Init initialization
+// Init initialization, also called on upgrade.
 func (t *AdChainChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	//-------------7 optional parameters------------
+	//     0(optional)       1(optional)         2(optional)		3(optional)
+	//  "LogLevel"    "RedactSensitive"      "Quorum"		"OrgRegistry"(<chaincodeName>, <chaincodeName>:<channel> or local)
+	//     4(optional)                        5(optional)
+	//  "AdminOrgs"(comma separated MSP ids)  "AuditorOrgs"(comma separated MSP ids)
+	//     6(optional)
+	//  "Config"(JSON document of PolicyConfig, only accepted by the first Init)
+	// An empty argument keeps the stored config, so an upgrade without arguments does not reset the configs.
+	//Init never ran if there is no logging config, which is written by every Init
+	loggingConfigJSONasBytes, err := stub.GetState(loggingConfigKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = initImportWindow(stub, loggingConfigJSONasBytes == nil)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = initLoggingConfig(stub, args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var quorum string
+	if len(args) > 2 {
+		quorum = args[2]
+	}
+	err = initGovernanceConfig(stub, quorum)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var orgRegistry string
+	if len(args) > 3 {
+		orgRegistry = args[3]
+	}
+	err = initRegistryConfig(stub, orgRegistry)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var adminOrgs, auditorOrgs string
+	if len(args) > 4 {
+		adminOrgs = args[4]
+	}
+	if len(args) > 5 {
+		auditorOrgs = args[5]
+	}
+	err = initRolesConfig(stub, adminOrgs, auditorOrgs)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var policy string
+	if len(args) > 6 {
+		policy = args[6]
+	}
+	err = initPolicyConfig(stub, policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	return shim.Success(nil)
 }
 
@@ -129,8 +225,20 @@ func (t *AdChainChaincode) Put(stub shim.ChaincodeStubInterface) pb.Response {
 // Invoke runs callback representing the invocation of a chaincode
 func (t *AdChainChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, _ := stub.GetFunctionAndParameters()
-	fmt.Println(" ")
-	fmt.Println("starting invoke, for - " + function)
+	err := loadLoggingConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	newTxLogger(stub).Infof("starting invoke, for - %s", function)
+
+	err = checkFunctionRoles(stub, function)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkFunctionEnabled(stub, function)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	// Handle different functions
 	if function == "Query" {
@@ -147,6 +255,54 @@ func (t *AdChainChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 		return t.PanelRequest(stub)
 	} else if function == "PanelUpdate" {
 		return t.PanelUpdate(stub)
+	} else if function == "Migrate" {
+		return t.Migrate(stub)
+	} else if function == "Propose" {
+		return t.Propose(stub)
+	} else if function == "Vote" {
+		return t.Vote(stub)
+	} else if function == "GetProposal" {
+		return t.GetProposal(stub)
+	} else if function == "ListProposals" {
+		return t.ListProposals(stub)
+	} else if function == "GetRecordHistory" {
+		return t.GetRecordHistory(stub)
+	} else if function == "SweepExpired" {
+		return t.SweepExpired(stub)
+	} else if function == "QueryExpiringData" {
+		return t.QueryExpiringData(stub)
+	} else if function == "VerifyArtifact" {
+		return t.VerifyArtifact(stub)
+	} else if function == "OnBoardingAttest" {
+		return t.OnBoardingAttest(stub)
+	} else if function == "GetQuota" {
+		return t.GetQuota(stub)
+	} else if function == "DataRegisterBatch" {
+		return t.DataRegisterBatch(stub)
+	} else if function == "ExportState" {
+		return t.ExportState(stub)
+	} else if function == "ImportState" {
+		return t.ImportState(stub)
+	} else if function == "OnBoardingDispute" {
+		return t.OnBoardingDispute(stub)
+	} else if function == "SweepAbandoned" {
+		return t.SweepAbandoned(stub)
+	} else if function == "GetReputation" {
+		return t.GetReputation(stub)
+	} else if function == "ApproveImport" {
+		return t.ApproveImport(stub)
+	} else if function == "CloseImport" {
+		return t.CloseImport(stub)
+	} else if function == "OrgSuspend" {
+		return t.OrgSuspend(stub)
+	} else if function == "OrgReinstate" {
+		return t.OrgReinstate(stub)
+	} else if function == "OrgDeregister" {
+		return t.OrgDeregister(stub)
+	} else if function == "GetConfig" {
+		return t.GetConfig(stub)
+	} else if function == "UpdateConfig" {
+		return t.UpdateConfig(stub)
 	}
 
 	return shim.Error("Received unknown function invocation")
@@ -160,7 +316,6 @@ func (t *AdChainChaincode) OrgRegister(stub shim.ChaincodeStubInterface) pb.Resp
 	function, _ := stub.GetFunctionAndParameters()
 
 	operationType := function
-	emptyQueryResults := []byte("[]")
 
 	ownerId, err := generateOwnerIdByCert(stub)
 	if err != nil {
@@ -171,13 +326,40 @@ func (t *AdChainChaincode) OrgRegister(stub shim.ChaincodeStubInterface) pb.Resp
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	//If the ownerId already registered before, just return.
-	queryResults, err := queryByOwnerAndOperationType(stub, operationType, ownerId)
+
+	publicKey, err := getPublicKeyPEM(stub)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	if bytes.Equal(queryResults[:], emptyQueryResults[:]) == false {
-		fmt.Printf("Already did OrgRegister:%s\n", queryResults)
+
+	//If the ownerId already registered before, just return. A deregistered ownerId can not register again.
+	//The org registered before public keys were kept only gets its public key.
+	org, key, err := getOrgRegistering(stub, ownerId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if org != nil {
+		if org.Status == orgStatusDeregistered {
+			return shim.Error(fmt.Sprintf("Current owner:%s has been deregistered, reason:%s", ownerId, org.StatusReason))
+		}
+		if len(org.PublicKey) > 0 {
+			newTxLogger(stub).Infof("Already did OrgRegister, owner:%s", redactOwner(ownerId))
+			return shim.Success(nil)
+		}
+		org.PublicKey = publicKey
+		dataJSONasBytes, err := json.Marshal(org)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		dataJSONasBytes, err = stampWriter(stub, dataJSONasBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(key), redactRecord(dataJSONasBytes))
+		err = stub.PutState(key, dataJSONasBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 		return shim.Success(nil)
 	}
 
@@ -186,15 +368,22 @@ func (t *AdChainChaincode) OrgRegister(stub shim.ChaincodeStubInterface) pb.Resp
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	data := &OrgRegistering{operationType,ownerId,orgName,commonName, txTimestamp}
+	data := &OrgRegistering{operationType,currentSchemaVersion,ownerId,orgName,commonName, txTimestamp,
+							orgStatusActive, "", "", pb_timestamp.Timestamp{0,0}, // statusTimestamp is 0 when registering.
+							publicKey,
+							""}
 	dataJSONasBytes, err := json.Marshal(data)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
 	// === Save org to state ===
-	key := operationType + "_" + ownerId
-	fmt.Printf("Starting PutState, key:%s, value:%s\n", key, string(dataJSONasBytes))
+	key = operationType + "_" + ownerId
+	dataJSONasBytes, err = stampWriter(stub, dataJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(key), redactRecord(dataJSONasBytes))
 	err = stub.PutState(key, dataJSONasBytes)
 	if err != nil {
 		return shim.Error(err.Error())
@@ -210,15 +399,20 @@ func (t *AdChainChaincode) OrgRegister(stub shim.ChaincodeStubInterface) pb.Resp
 func (t *AdChainChaincode) DataRegister(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 	//-------------3 parameters is necessary------------
-	//     0       		1       	2		3		  4			5						6
-	// "DataType", "DataName", "LineCount" "HLL"	"Bloom"	  "Tag"(optional)	  "Field"(optional)
+	//     0       		1       	2		3		  4			5						6					7									8							9
+	// "DataType", "DataName", "LineCount" "HLL"	"Bloom"	  "Tag"(optional)	  "Field"(optional)	  "Expiry"(optional, 720h or RFC3339)  "HLLArtifact"(optional)  "BloomArtifact"(optional)
+	// The artifacts are combined by: Hash|Size|MediaType|URI, like sha256:<hex>|1048576|application/octet-stream|ipfs://<cid>
+	//-------------transient map(optional)------------
+	// "hll", "bloom": sent instead of the HLL and Bloom arguments, they are encrypted before saving.
+	// "recipient": ownerId who can decrypt them, current owner by default.
+	// "encryptionKey", "encryptionNonce": the secret randomness of the encryption, necessary with "hll" or "bloom", see EncryptedPayload.
 
 	// ==== Input sanitation ====
 	if len(args) < 5 {
 		return shim.Error("Incorrect number of arguments. Expecting at least 5 parameters for DataRegister")
 	}
 	//if there is any empty string parameters, return err.
-	//does not check for last 4 arguments
+	//does not check for last 5 arguments
 	for i := 0; i < 3; i++ {
 		if len(args[i]) <= 0 {
 			return shim.Error(strconv.Itoa(i) + "th argument must be a non-empty string")
@@ -230,12 +424,33 @@ func (t *AdChainChaincode) DataRegister(stub shim.ChaincodeStubInterface) pb.Res
 		return shim.Error(err.Error())
 	}
 
+	//a suspended or deregistered owner can not register new data
+	org, _, err := getOrgRegistering(stub, ownerId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if org != nil {
+		err = checkOrgStatus(org, "Current owner")
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
 	operationType := function
 	emptyQueryResults := []byte("[]")
 
 	dataType := strings.ToLower(args[0])
 	dataName := args[1]
 
+	policy, err := getPolicyConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkDataType(policy, dataType)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	lineCount, err := strconv.Atoi(args[2])
 	if err != nil {
 		return shim.Error("3th argument must be a numeric string as lineCount of DataRegister.")
@@ -244,13 +459,71 @@ func (t *AdChainChaincode) DataRegister(stub shim.ChaincodeStubInterface) pb.Res
 	hll := args[3]
 	bloom := args[4]
 
-	//necessary for panel, currently only have one tag:gender, field might be one of: male; female; all
+	//the sketches sent in the transient map never reach the state database in clear text
+	var encryptedSketches [2]*EncryptedPayload
+	recipient, err := getTransientPayload(stub, "recipient")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if recipient == nil {
+		recipient = []byte(ownerId)
+	}
+	for i, name := range []string{"hll", "bloom"} {
+		sketch, err := getTransientPayload(stub, name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if sketch == nil {
+			continue
+		}
+		if len(args[3+i]) > 0 {
+			return shim.Error(fmt.Sprintf("%s must be sent either in the arguments or in the transient map, not both.", name))
+		}
+		encryptedSketches[i], err = encryptForOwner(stub, strings.ToLower(string(recipient)), name, sketch)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	//necessary for panel, the tags are limited by the policy config, field of gender might be one of: male; female; all
 	var tag string
 	var field string
 	if len(args) >= 7 {
 		tag = args[5]
 		field = args[6]
 	}
+	if len(tag) > 0 {
+		err = checkTag(policy, strings.ToLower(tag))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	//the expiry is either a duration counted from now, or an absolute time. Without it the dataExpiry of the policy config applies.
+	expiryTimestamp := defaultDataExpiry(policy, txTimestamp)
+	if len(args) >= 8 && len(args[7]) > 0 {
+		expiryTimestamp, err = parseDeadline(txTimestamp, args[7])
+		if err != nil {
+			return shim.Error("8th argument must be a duration(like 720h) or a RFC3339 time as expiry of DataRegister.")
+		}
+		if !isBefore(txTimestamp, expiryTimestamp) {
+			return shim.Error("8th argument must be an expiry in the future.")
+		}
+	}
+
+	//the sketches kept off-chain are bound to the data by their content hash
+	var artifacts [2]*ArtifactRef
+	for i := 0; i < 2 && len(args) > 8+i; i++ {
+		artifacts[i], err = parseArtifactRef(args[8+i])
+		if err != nil {
+			return shim.Error(strconv.Itoa(8+i) + "th argument is invalid: " + err.Error())
+		}
+	}
 
 	//If the ownerId already registered this data before, just return.
 	queryResults, err := queryByDataAndOperationType(stub, operationType, ownerId, dataName)
@@ -258,17 +531,18 @@ func (t *AdChainChaincode) DataRegister(stub shim.ChaincodeStubInterface) pb.Res
 		return shim.Error(err.Error())
 	}
 	if bytes.Equal(queryResults[:], emptyQueryResults[:]) == false {
-		fmt.Printf("Already did DataRegister:%s\n", queryResults)
+		newTxLogger(stub).Infof("Already did DataRegister:%s", redactRecord(queryResults))
 		return shim.Success(nil)
 	}
 
-	// === prepare the org json ===
-	txTimestamp, err := getTxTimestamp(stub)
+	err = consumeQuota(stub, ownerId, operationType)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
+	// === prepare the org json ===
 	data := &DataRegistering{operationType,
+							 currentSchemaVersion,
 							 dataType,
 							 ownerId,
 							 dataName,
@@ -279,7 +553,14 @@ func (t *AdChainChaincode) DataRegister(stub shim.ChaincodeStubInterface) pb.Res
 		                     field,
 							 txTimestamp,
 							 0,
-							 pb_timestamp.Timestamp{0,0}} // lastMatchTimestamp is 0 when registering.
+							 pb_timestamp.Timestamp{0,0}, // lastMatchTimestamp is 0 when registering.
+							 expiryTimestamp,
+							 false,
+							 encryptedSketches[0],
+							 encryptedSketches[1],
+							 artifacts[0],
+							 artifacts[1],
+							 ""}
 
 	dataJSONasBytes, err := json.Marshal(data)
 	if err != nil {
@@ -288,7 +569,11 @@ func (t *AdChainChaincode) DataRegister(stub shim.ChaincodeStubInterface) pb.Res
 
 	// === Save data to state ===
 	key := operationType + "_" + ownerId + "_" + dataName
-	fmt.Printf("Starting PutState, key:%s, value:%s\n", key, string(dataJSONasBytes))
+	dataJSONasBytes, err = stampWriter(stub, dataJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(key), redactRecord(dataJSONasBytes))
 	err = stub.PutState(key, dataJSONasBytes)
 	if err != nil {
 		return shim.Error(err.Error())
@@ -303,8 +588,14 @@ func (t *AdChainChaincode) DataRegister(stub shim.ChaincodeStubInterface) pb.Res
 func (t *AdChainChaincode) OnBoarding(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 	//---------------------------------------7 parameters-------------------------------------------------
-	//     0       	 1       		2     		  		3  			   	  4			 	  5			  6				7		  8
-	//  "Step",   "OwnerId",	"DataName", "FilteredLineCount",  "TargetOwner", "TargetDataName", "IsFinished", "Bloom"	"TxID"(optional)
+	//     0       	 1       		2     		  		3  			   	  4			 	  5			  6				7		  8					9													10
+	//  "Step",   "OwnerId",	"DataName", "FilteredLineCount",  "TargetOwner", "TargetDataName", "IsFinished", "Bloom"	"TxID"(optional)  "BloomArtifact"(optional, Hash|Size|MediaType|URI)  "ActingParty"(necessary for step > 1)
+	//The ActingParty is the ownerId of the party submitting this step(OwnerId or TargetOwner), it must match the cert of submitter.
+	//The other party countersigns the result by OnBoardingAttest.
+	//-------------transient map(optional)------------
+	// "bloom": the Bloom of this step, it is encrypted to the counterparty(targetOwner if current owner is the owner, otherwise owner).
+	//          The Bloom argument must be empty then.
+	// "encryptionKey", "encryptionNonce": the secret randomness of the encryption, necessary with "bloom", see EncryptedPayload.
 
 	//TODO: TxID is added for panel to track all the progress for panel transaction(PanelRequest, OnBoarding, ... etc.)
 	//TODO: Add checking for dataType of both data, should be the same
@@ -313,8 +604,19 @@ func (t *AdChainChaincode) OnBoarding(stub shim.ChaincodeStubInterface) pb.Respo
 	if len(args) < 8 {
 		return shim.Error("Incorrect number of arguments. Expecting at least 8 parameters for OnBoarding")
 	}
+	//the Bloom sent in the transient map replaces the Bloom argument, which should be empty then.
+	bloom, err := getTransientPayload(stub, "bloom")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if bloom != nil && len(args[7]) > 0 {
+		return shim.Error("bloom must be sent either in the arguments or in the transient map, not both.")
+	}
 	// if there is any empty string parameters, return err.
 	for i := 0; i < 8; i++ {
+		if i == 7 && bloom != nil {
+			continue
+		}
 		if len(args[i]) <= 0 {
 			return shim.Error(strconv.Itoa(i) + "th argument must be a non-empty string")
 		}
@@ -353,11 +655,39 @@ func (t *AdChainChaincode) OnBoarding(stub shim.ChaincodeStubInterface) pb.Respo
 		txID = args[8]
 	}
 
+	//the Bloom of this step kept off-chain is bound to the step by its content hash
+	var bloomArtifact *ArtifactRef
+	if len(args) > 9 {
+		bloomArtifact, err = parseArtifactRef(args[9])
+		if err != nil {
+			return shim.Error("9th argument is invalid: " + err.Error())
+		}
+	}
+
+	//step 1 is always submitted by the owner
+	var actingParty string
+	if len(args) > 10 {
+		actingParty = strings.ToLower(args[10])
+	}
+	if step == 1 && len(actingParty) == 0 {
+		actingParty = ownerId
+	}
+	if len(actingParty) == 0 {
+		return shim.Error("10th argument must be a non-empty string as actingParty of OnBoarding, step > 1")
+	}
+	err = checkActingParty(stub, actingParty, ownerId, targetOwner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	////targetOwner should not be the same as owner
 	//if ownerId == targetOwner {
 	//	return shim.Error("The targetOwner should not be the same as current owner.")
 	//}
 
+	var flags []string
+	var startTimestamp pb_timestamp.Timestamp
+	var previousLineCount int
 	if step == 1 {
 		//for step 1, check whether the owner is current owner
 		currentOwnerId, err := generateOwnerIdByCert(stub)
@@ -368,34 +698,30 @@ func (t *AdChainChaincode) OnBoarding(stub shim.ChaincodeStubInterface) pb.Respo
 			return shim.Error(fmt.Sprintf("Current ownerId:%s does not equal to the ownerId:%s in argument, step=1", currentOwnerId, ownerId))
 		}
 
-		//for step 1, check whether the owner exists, whether TargetOwner exists
-		queryResults, err := queryByOwnerAndOperationType(stub, "OrgRegister", ownerId)
+		//for step 1, check whether the owner exists, whether TargetOwner exists, and both of them are active
+		err = checkOrgActive(stub, ownerId, "Current owner")
 		if err != nil {
 			return shim.Error(err.Error())
 		}
-		if bytes.Equal(queryResults[:], emptyQueryResults[:]) {
-			fmt.Sprintf("Current owner:%s has not registered yet, please do OrgRegister first.\n", ownerId)
-			return shim.Error(fmt.Sprintf("Current owner:%s has not registered yet, please do OrgRegister first.", ownerId))
-		}
 
-		queryResults, err = queryByOwnerAndOperationType(stub, "OrgRegister", targetOwner)
+		err = checkOrgActive(stub, targetOwner, "targetOwner")
 		if err != nil {
 			return shim.Error(err.Error())
 		}
-		if bytes.Equal(queryResults[:], emptyQueryResults[:]) {
-			fmt.Sprintf("targetOwner:%s has not registered yet, please do OrgRegister first.\n", targetOwner)
-			return shim.Error(fmt.Sprintf("targetOwner:%s has not registered yet, please do OrgRegister first.", targetOwner))
-		}
 
 		//for step 1, check whether the DataName exists, whether the TargetDataName exists
-		queryResults, err = queryByDataAndOperationType(stub, "DataRegister", ownerId, dataName)
+		queryResults, err := queryByDataAndOperationType(stub, "DataRegister", ownerId, dataName)
 		if err != nil {
 			return shim.Error(err.Error())
 		}
 		if bytes.Equal(queryResults[:], emptyQueryResults[:]) {
-			fmt.Sprintf("Current owner:%s doesn't have data:%s yet, please do DataRegister for this data first.\n", ownerId, dataName)
+			newTxLogger(stub).Warningf("Current owner:%s doesn't have data:%s yet, please do DataRegister for this data first.", redactOwner(ownerId), dataName)
 			return shim.Error(fmt.Sprintf("Current owner:%s doesn't have data:%s yet, please do DataRegister for this data first.", ownerId, dataName))
 		}
+		err = checkDataNotExpired(stub, queryResults)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 
 		queryResults, err = queryByDataAndOperationType(stub, "DataRegister", targetOwner, targetDataName)
 		if err != nil {
@@ -403,9 +729,13 @@ func (t *AdChainChaincode) OnBoarding(stub shim.ChaincodeStubInterface) pb.Respo
 		}
 
 		if bytes.Equal(queryResults[:], emptyQueryResults[:]) {
-			fmt.Sprintf("The targetOwner:%s doesn't have data:%s yet, please double check.\n", targetOwner, targetDataName)
+			newTxLogger(stub).Warningf("The targetOwner:%s doesn't have data:%s yet, please double check.", redactOwner(targetOwner), targetDataName)
 			return shim.Error(fmt.Sprintf("The targetOwner:%s doesn't have data:%s yet, please double check.", targetOwner, targetDataName))
 		}
+		err = checkDataNotExpired(stub, queryResults)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 
 		//for step 1, need to check whether the matching for these pair of data ever happened before, if Yes, just return with notice.
 		//define queryResult(but not queryResults), due to queryByStepAndOperationType will return only one single result.
@@ -426,6 +756,18 @@ func (t *AdChainChaincode) OnBoarding(stub shim.ChaincodeStubInterface) pb.Respo
 				return shim.Error(fmt.Sprintf("This OnBoarding action already finished before, txID:%s", dataJSON.TxID))
 			}
 		}
+
+		//only the new sessions count against the quota of the owner
+		err = consumeQuota(stub, ownerId, operationType)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		//the filteredLineCount of step 1 is consistent if it is not more than the lines of the data
+		previousLineCount, err = registeredLineCount(stub, ownerId, dataName)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 	} else {
 		//here means step > 1
 		//check step, whether there is a (step - 1) happened before to make sure this is correct step. Also the step should not finished(isFinished==false)
@@ -444,9 +786,37 @@ func (t *AdChainChaincode) OnBoarding(stub shim.ChaincodeStubInterface) pb.Respo
 		}
 
 		txID = dataJSON.TxID	//reuse the txID of previous step
+		flags = dataJSON.Flags	//keep the flags of previous step
+		startTimestamp = dataJSON.StartTimestamp
+		previousLineCount = dataJSON.FilteredLineCount
 		if dataJSON.IsFinished {
 			return shim.Error(fmt.Sprintf("This OnBoarding action already finished on step:%d, txID:%s", step - 1, txID))
 		}
+		if dataJSON.IsAbandoned {
+			return shim.Error(fmt.Sprintf("This OnBoarding action was abandoned after step:%d, txID:%s, please start a new one.", step - 1, txID))
+		}
+
+		//a suspended or deregistered party can not continue the matching
+		err = checkOrgActive(stub, ownerId, "Current owner")
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = checkOrgActive(stub, targetOwner, "targetOwner")
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		//the data might expire while the matching is in-flight
+		for _, data := range [][]string{{ownerId, dataName}, {targetOwner, targetDataName}} {
+			queryResults, err := queryByDataAndOperationType(stub, "DataRegister", data[0], data[1])
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			err = checkDataNotExpired(stub, queryResults)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
 	}
 
 	// === prepare the OnBoarding json ===
@@ -454,8 +824,24 @@ func (t *AdChainChaincode) OnBoarding(stub shim.ChaincodeStubInterface) pb.Respo
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	if step == 1 {
+		startTimestamp = txTimestamp
+	}
+
+	var encryptedBloom *EncryptedPayload
+	if bloom != nil {
+		counterparty := ownerId
+		if actingParty == ownerId {
+			counterparty = targetOwner
+		}
+		encryptedBloom, err = encryptForOwner(stub, counterparty, "bloom", bloom)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
 
 	data := &OnBoarding{operationType,
+						currentSchemaVersion,
 						txID,
 						step,
 						ownerId,
@@ -464,7 +850,16 @@ func (t *AdChainChaincode) OnBoarding(stub shim.ChaincodeStubInterface) pb.Respo
 						targetOwner,
 						targetDataName,
 						isFinished,
-						txTimestamp}
+						txTimestamp,
+						flags,
+						encryptedBloom,
+						bloomArtifact,
+						actingParty,
+						nil,
+						nil,
+						startTimestamp,
+						false,
+						""}
 
 	dataJSONasBytes, err := json.Marshal(data)
 	if err != nil {
@@ -473,12 +868,24 @@ func (t *AdChainChaincode) OnBoarding(stub shim.ChaincodeStubInterface) pb.Respo
 
 	// === Save matching step to state ===
 	key := operationType + "_" + txID
-	fmt.Printf("Starting PutState, key:%s, value:%s\n", key, string(dataJSONasBytes))
+	dataJSONasBytes, err = stampWriter(stub, dataJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(key), redactRecord(dataJSONasBytes))
 	err = stub.PutState(key, dataJSONasBytes)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
+	// === Save the outcome of this step to the reputation of both parties ===
+	deltas := reputationDeltas{}
+	recordStepOutcome(deltas, data, previousLineCount)
+	err = applyReputationDeltas(stub, deltas)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	// === Save data MatchCount and LastMatchTimestamp to state ===
 	if isFinished == true {
 		operationType = "DataRegister"
@@ -507,7 +914,11 @@ func (t *AdChainChaincode) OnBoarding(stub shim.ChaincodeStubInterface) pb.Respo
 		if err != nil {
 			return shim.Error(err.Error())
 		}
-		fmt.Printf("Starting PutState, key:%s, value:%s\n", key, string(dataJSONasBytes))
+		dataJSONasBytes, err = stampWriter(stub, dataJSONasBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(key), redactRecord(dataJSONasBytes))
 		err = stub.PutState(key, dataJSONasBytes)
 		if err != nil {
 			return shim.Error(err.Error())
@@ -519,7 +930,7 @@ func (t *AdChainChaincode) OnBoarding(stub shim.ChaincodeStubInterface) pb.Respo
 
 
 // ============================================================================================================================
-// Query - query who am I, if I already registered, return the registered record, otherwise return nil.
+// Query - query who am I, return the identity and roles taken from the cert, together with the registered record if I already registered.
 // ============================================================================================================================
 func (t *AdChainChaincode) WhoAmI(stub shim.ChaincodeStubInterface) pb.Response {
 
@@ -528,18 +939,35 @@ func (t *AdChainChaincode) WhoAmI(stub shim.ChaincodeStubInterface) pb.Response
 		return shim.Error(err.Error())
 	}
 
+	orgName, commonName, err := getOrgNameAndCommonName(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	roles, err := getRoles(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	operationType := "OrgRegister"
 	emptyQueryResults := []byte("[]")
 
-	//If the ownerId already registered before, just return the history registered info.
+	identity := &Identity{ownerId, orgName, commonName, roles, nil}
+
+	//If the ownerId already registered before, also return the history registered info.
 	queryResults, err := queryByOwnerAndOperationType(stub, operationType, ownerId)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 	if bytes.Equal(queryResults[:], emptyQueryResults[:]) == false {
-		return shim.Success(queryResults)
+		identity.Registered = queryResults
 	}
-	return shim.Success(nil)
+
+	identityJSONasBytes, err := json.Marshal(identity)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(identityJSONasBytes)
 }
 
 // ============================================================================================================================
@@ -548,7 +976,7 @@ func (t *AdChainChaincode) WhoAmI(stub shim.ChaincodeStubInterface) pb.Response
 func (t *AdChainChaincode) Query(stub shim.ChaincodeStubInterface) pb.Response {
 	_, args := stub.GetFunctionAndParameters()
 	var err error
-	fmt.Println("starting Query")
+	newTxLogger(stub).Debugf("starting Query")
 
 	if len(args) != 1 {
 		return shim.Error("Incorrect number of arguments. Expecting query string of JSON to query")
@@ -567,13 +995,13 @@ func (t *AdChainChaincode) Query(stub shim.ChaincodeStubInterface) pb.Response {
 // ============================================================================================================================
 func queryByOwnerAndOperationType(stub shim.ChaincodeStubInterface, operationType string, ownerId string) ([]byte, error) {
 	var err error
-	fmt.Println("starting queryByOwnerAndOperationType")
+	newTxLogger(stub).Debugf("starting queryByOwnerAndOperationType")
 
 	if len(operationType) == 0 {
 		return nil, errors.New("Incorrect operationType. Expecting non empty type.")
 	}
-	if len(ownerId) != 32 {
-		return nil, errors.New("Incorrect ownerId. Expecting 16 bytes of md5 hash which has len == 32 of hex string.")
+	if err = checkOwnerId("ownerId", ownerId); err != nil {
+		return nil, err
 	}
 
 	queryString := fmt.Sprintf("{\"selector\":{\"operationType\":\"%s\",\"owner\":\"%s\"}}", operationType, ownerId)
@@ -589,13 +1017,13 @@ func queryByOwnerAndOperationType(stub shim.ChaincodeStubInterface, operationTyp
 // ============================================================================================================================
 func queryByDataAndOperationType(stub shim.ChaincodeStubInterface, operationType string, ownerId string, dataName string) ([]byte, error) {
 	var err error
-	fmt.Println("starting queryByDataAndOperationType")
+	newTxLogger(stub).Debugf("starting queryByDataAndOperationType")
 
 	if len(operationType) == 0 {
 		return nil, errors.New("Incorrect operationType. Expecting non empty type.")
 	}
-	if len(ownerId) != 32 {
-		return nil, errors.New("Incorrect ownerId. Expecting 16 bytes of md5 hash which has len == 32 of hex string.")
+	if err = checkOwnerId("ownerId", ownerId); err != nil {
+		return nil, err
 	}
 	if len(dataName) == 0 {
 		return nil, errors.New("Incorrect dataName. Expecting non empty dataName.")
@@ -622,7 +1050,7 @@ func queryByStepAndOperationType(stub shim.ChaincodeStubInterface,
 	targetOwner string,
 	targetDataName string) ([]byte, error) {
 	var err error
-	fmt.Println("starting queryByDataAndOperationType")
+	newTxLogger(stub).Debugf("starting queryByDataAndOperationType")
 
 	if len(operationType) == 0 {
 		return nil, errors.New("Incorrect operationType. Expecting non empty type.")
@@ -630,8 +1058,11 @@ func queryByStepAndOperationType(stub shim.ChaincodeStubInterface,
 	if byStep && step < 1 {
 		return nil, errors.New("Incorrect step. Expecting step >= 1.")
 	}
-	if len(ownerId) != 32 || len(targetOwner) != 32 {
-		return nil, errors.New(fmt.Sprintf("Incorrect owner or targetOwner. Expecting 16 bytes of md5 hash which has len == 32 of hex string.ownerId:%s", ownerId))
+	if err = checkOwnerId("ownerId", ownerId); err != nil {
+		return nil, err
+	}
+	if err = checkOwnerId("targetOwner", targetOwner); err != nil {
+		return nil, err
 	}
 	if len(dataName) == 0 || len(targetDataName) == 0 {
 		return nil, errors.New("Incorrect dataName or targetDataName. Expecting non empty dataName and targetDataName.")
@@ -646,7 +1077,7 @@ func queryByStepAndOperationType(stub shim.ChaincodeStubInterface,
 			operationType, ownerId, dataName, targetOwner, targetDataName)
 	}
 
-	fmt.Printf("- queryByStepAndOperationType queryString:\n%s\n", queryString)
+	newTxLogger(stub).Debugf("- queryByStepAndOperationType queryString:%s", redactRecord([]byte(queryString)))
 
 	resultsIterator, err := stub.GetQueryResult(queryString)
 	if err != nil {
@@ -660,9 +1091,13 @@ func queryByStepAndOperationType(stub shim.ChaincodeStubInterface,
 	if err != nil {
 		return nil, err
 	}
+	queryResult, _, err := upgradeRecord(queryResponse.Value)
+	if err != nil {
+		return nil, err
+	}
 
-	fmt.Printf("- queryByStepAndOperationType queryResult:\n%s\n", queryResponse.Value)
-	return queryResponse.Value, nil
+	newTxLogger(stub).Debugf("- queryByStepAndOperationType queryResult:%s", redactRecord(queryResult))
+	return queryResult, nil
 }
 
 // =========================================================================================
@@ -671,7 +1106,7 @@ func queryByStepAndOperationType(stub shim.ChaincodeStubInterface,
 // =========================================================================================
 func getQueryResultForQueryString(stub shim.ChaincodeStubInterface, queryString string) ([]byte, error) {
 
-	fmt.Printf("- getQueryResultForQueryString queryString:\n%s\n", queryString)
+	newTxLogger(stub).Debugf("- getQueryResultForQueryString queryString:%s", redactRecord([]byte(queryString)))
 
 	resultsIterator, err := stub.GetQueryResult(queryString)
 	if err != nil {
@@ -689,6 +1124,11 @@ func getQueryResultForQueryString(stub shim.ChaincodeStubInterface, queryString
 		if err != nil {
 			return nil, err
 		}
+		// Older records are upgraded to the currentSchemaVersion before returning
+		record, _, err := upgradeRecord(queryResponse.Value)
+		if err != nil {
+			return nil, err
+		}
 		// Add a comma before array members, suppress it for the first array member
 		if bArrayMemberAlreadyWritten == true {
 			buffer.WriteString(",")
@@ -700,13 +1140,13 @@ func getQueryResultForQueryString(stub shim.ChaincodeStubInterface, queryString
 
 		buffer.WriteString(", \"Record\":")
 		// Record is a JSON object, so we write as-is
-		buffer.WriteString(string(queryResponse.Value))
+		buffer.WriteString(string(record))
 		buffer.WriteString("}")
 		bArrayMemberAlreadyWritten = true
 	}
 	buffer.WriteString("]")
 
-	fmt.Printf("- getQueryResultForQueryString queryResult:\n%s\n", buffer.String())
+	newTxLogger(stub).Debugf("- getQueryResultForQueryString queryResult:%s", redactRecord(buffer.Bytes()))
 	return buffer.Bytes(), nil
 }
 
@@ -721,7 +1161,6 @@ func md5_hash(idBytes []byte) (string, error) {
 	digest := md5.New()
 	digest.Write(idBytes)
 	hash_cert := digest.Sum(nil)
-	fmt.Printf("MD5 Hash of idBytes Hex:%x\n", hash_cert) // 16 bytes
 	return fmt.Sprintf("%x", hash_cert), nil
 }
 
@@ -732,19 +1171,39 @@ func md5_hash(idBytes []byte) (string, error) {
 func getCert(stub shim.ChaincodeStubInterface) ([]byte, error) {
 	creator, err := stub.GetCreator()
 	if err != nil {
-		fmt.Errorf("Failed to get creator info")
+		newTxLogger(stub).Errorf("Failed to get creator info, err:%s", err)
 		return nil, err
 	}
 
 	serializedIdentity := &pb_msp.SerializedIdentity{}
 	err = proto.Unmarshal(creator, serializedIdentity)
 	if err != nil {
-		fmt.Sprintf("Failed to Unmarshal serializedIdentity, err %s", err)
+		newTxLogger(stub).Errorf("Failed to Unmarshal serializedIdentity, err:%s", err)
 		return nil, err
 	}
 	return serializedIdentity.IdBytes, nil
 }
 
+// ========================================================
+// getMspId is used to unmarshal the creator
+// return the MSP id of the creator
+// ========================================================
+func getMspId(stub shim.ChaincodeStubInterface) (string, error) {
+	creator, err := stub.GetCreator()
+	if err != nil {
+		newTxLogger(stub).Errorf("Failed to get creator info, err:%s", err)
+		return "", err
+	}
+
+	serializedIdentity := &pb_msp.SerializedIdentity{}
+	err = proto.Unmarshal(creator, serializedIdentity)
+	if err != nil {
+		newTxLogger(stub).Errorf("Failed to Unmarshal serializedIdentity, err:%s", err)
+		return "", err
+	}
+	return serializedIdentity.Mspid, nil
+}
+
 // ============================================================================================================================
 // Generate the ownerId which is the md5 hash value of cert
 // ============================================================================================================================
@@ -764,7 +1223,7 @@ func generateOwnerIdByCert(stub shim.ChaincodeStubInterface) (string, error) {
 func getTxTimestamp(stub shim.ChaincodeStubInterface) (pb_timestamp.Timestamp, error) {
 	pTxTimestamp, err := stub.GetTxTimestamp()
 	if err != nil {
-		fmt.Errorf("Failed to call stub.GetTxTimestamp, err:%s\n",err.Error())
+		newTxLogger(stub).Errorf("Failed to call stub.GetTxTimestamp, err:%s", err)
 		return pb_timestamp.Timestamp{}, err
 	}
 	txTimestamp := pb_timestamp.Timestamp{}
@@ -773,27 +1232,39 @@ func getTxTimestamp(stub shim.ChaincodeStubInterface) (pb_timestamp.Timestamp, e
 	return txTimestamp, nil
 }
 
+// ========================================================
+// parseDeadline accepts a duration(like 72h) counted from the txTimestamp, or an absolute RFC3339 time.
+// ========================================================
+func parseDeadline(txTimestamp pb_timestamp.Timestamp, value string) (pb_timestamp.Timestamp, error) {
+	var deadline time.Time
+	duration, err := time.ParseDuration(value)
+	if err == nil {
+		deadline = time.Unix(txTimestamp.Seconds, int64(txTimestamp.Nanos)).Add(duration)
+	} else {
+		deadline, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return pb_timestamp.Timestamp{}, err
+		}
+	}
+	return pb_timestamp.Timestamp{Seconds: deadline.Unix(), Nanos: int32(deadline.Nanosecond())}, nil
+}
+
+// isBefore returns true if timestamp a happens before b.
+func isBefore(a pb_timestamp.Timestamp, b pb_timestamp.Timestamp) bool {
+	return a.Seconds < b.Seconds || (a.Seconds == b.Seconds && a.Nanos < b.Nanos)
+}
+
 // ========================================================
 // Parse the cert to fetch org name and common name
 // return both orgName and commonName
 // ========================================================
 func getOrgNameAndCommonName(stub shim.ChaincodeStubInterface) (string, string, error) {
 
-	idBytes, err := getCert(stub)
+	cert, err := getX509Cert(stub)
 	if err != nil {
 		return "", "", err
 	}
 
-	block, _ := pem.Decode([]byte(idBytes))
-	if block == nil {
-		return "", "", errors.New("Failed to parse certificate PEM")
-	}
-
-	cert, err := x509.ParseCertificate(block.Bytes)
-	if err != nil {
-		return "", "", errors.New(fmt.Sprintf("Failed to ParseCertificate, err %s", err))
-	}
-
 	orgNameArray := cert.Subject.Organization
 	var orgName string
 	if len(orgNameArray) == 0 {
@@ -809,6 +1280,50 @@ func getOrgNameAndCommonName(stub shim.ChaincodeStubInterface) (string, string,
 	return orgName, commonName, nil
 }
 
+// ========================================================
+// Parse the cert to fetch the roles(admin, auditor, data-provider, sponsor)
+// from the OU of subject or the fabric-ca attributes.
+// ========================================================
+func getRoles(stub shim.ChaincodeStubInterface) ([]string, error) {
+
+	cert, err := getX509Cert(stub)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := getRolesFromCert(cert)
+	if err != nil {
+		return nil, err
+	}
+	mspId, err := getMspId(stub)
+	if err != nil {
+		return nil, err
+	}
+	return filterPrivilegedRoles(stub, roles, mspId)
+}
+
+// ========================================================
+// getX509Cert is used to parse the PEM cert of creator
+// return *x509.Certificate
+// ========================================================
+func getX509Cert(stub shim.ChaincodeStubInterface) (*x509.Certificate, error) {
+
+	idBytes, err := getCert(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode([]byte(idBytes))
+	if block == nil {
+		return nil, errors.New("Failed to parse certificate PEM")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to ParseCertificate, err %s", err))
+	}
+	return cert, nil
+}
+
 // ===============================================New support for panel========================================================
 //
 // ============================================================================================================================
@@ -857,38 +1372,50 @@ func (t *AdChainChaincode) PanelRequest(stub shim.ChaincodeStubInterface) pb.Res
 	//	return shim.Error("Incorrect Providers argument. Expecting 2 different providerIds for PanelRequest, now they are the same.")
 	//}
 
-	//check whether the owner exists, whether providers exists
-	queryResults, err := queryByOwnerAndOperationType(stub, "OrgRegister", ownerId)
+	//check whether the owner exists, whether providers exists, and all of them are active
+	err = checkOrgActive(stub, ownerId, "Current owner")
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	if bytes.Equal(queryResults[:], emptyQueryResults[:]) {
-		return shim.Error(fmt.Sprintf("Current owner:%s has not registered yet, please do OrgRegister first.", ownerId))
-	}
 
 	for i := 0; i < len(providerIdList); i++ {
-		queryResults, err := queryByOwnerAndOperationType(stub, "OrgRegister", providerIdList[i])
+		err = checkOrgActive(stub, providerIdList[i], "ProviderId")
 		if err != nil {
 			return shim.Error(err.Error())
 		}
-		if bytes.Equal(queryResults[:], emptyQueryResults[:]) {
-			return shim.Error(fmt.Sprintf("ProviderId:%s has not registered yet, please do OrgRegister first.", providerIdList[i]))
-		}
 	}
 
 	//check whether the DataName(which belongs to ownerId) exists. Do not check the panel data exists or not here, because they will be checked inside onboarding.
-	queryResults, err = queryByDataAndOperationType(stub, "DataRegister", ownerId, dataName)
+	queryResults, err := queryByDataAndOperationType(stub, "DataRegister", ownerId, dataName)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 	if bytes.Equal(queryResults[:], emptyQueryResults[:]) {
-		fmt.Sprintf("Current owner:%s doesn't have data:%s yet, please do DataRegister for this data first.\n", ownerId, dataName)
+		newTxLogger(stub).Warningf("Current owner:%s doesn't have data:%s yet, please do DataRegister for this data first.", redactOwner(ownerId), dataName)
 		return shim.Error(fmt.Sprintf("Current owner:%s doesn't have data:%s yet, please do DataRegister for this data first.", ownerId, dataName))
 	}
+	err = checkDataNotExpired(stub, queryResults)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	policy, err := getPolicyConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkTag(policy, tag)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = consumeQuota(stub, ownerId, operationType)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	// === prepare the Paneling json ===
 	var providers Providers
-	//new object based on the tag, we might support other tags later here.
+	//new object based on the tag, a new tag has to be added to supportedTags too.
 	if tag == "gender" {
 		var genderProviderArray []GenderProvider
 		genderProviderArray = append(genderProviderArray, GenderProvider{providerIdList[0], Gender{}})
@@ -904,6 +1431,7 @@ func (t *AdChainChaincode) PanelRequest(stub shim.ChaincodeStubInterface) pb.Res
 	}
 
 	data := &Paneling{operationType,
+							 currentSchemaVersion,
 		              		 txID,
 							 ownerId,
 							 dataType,
@@ -911,7 +1439,9 @@ func (t *AdChainChaincode) PanelRequest(stub shim.ChaincodeStubInterface) pb.Res
 		                     providers,
 							 false,
 							 txTimestamp,
-							 pb_timestamp.Timestamp{0,0}} // lastMatchTimestamp is 0 when registering.
+							 pb_timestamp.Timestamp{0,0}, // lastMatchTimestamp is 0 when registering.
+							 nil,
+							 ""}
 
 	dataJSONasBytes, err := json.Marshal(data)
 	if err != nil {
@@ -920,7 +1450,11 @@ func (t *AdChainChaincode) PanelRequest(stub shim.ChaincodeStubInterface) pb.Res
 
 	// === Save data to state ===
 	key := operationType + "_" + txID
-	fmt.Printf("Starting PutState, key:%s, value:%s\n", key, string(dataJSONasBytes))
+	dataJSONasBytes, err = stampWriter(stub, dataJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(key), redactRecord(dataJSONasBytes))
 	err = stub.PutState(key, dataJSONasBytes)
 	if err != nil {
 		return shim.Error(err.Error())
@@ -933,7 +1467,7 @@ func (t *AdChainChaincode) PanelRequest(stub shim.ChaincodeStubInterface) pb.Res
 // ============================================================================================================================
 func queryByTxIDAndOperationType(stub shim.ChaincodeStubInterface, operationType string, txID string) ([]byte, error) {
 	var err error
-	fmt.Println("starting queryByTxIDAndOperationType")
+	newTxLogger(stub).Debugf("starting queryByTxIDAndOperationType")
 
 	if len(operationType) == 0 {
 		return nil, errors.New("Incorrect operationType. Expecting non empty type.")
@@ -957,9 +1491,13 @@ func queryByTxIDAndOperationType(stub shim.ChaincodeStubInterface, operationType
 	if err != nil {
 		return nil, err
 	}
+	queryResult, _, err := upgradeRecord(queryResponse.Value)
+	if err != nil {
+		return nil, err
+	}
 
-	fmt.Printf("- queryByTxIDAndOperationType queryResult:\n%s\n", queryResponse.Value)
-	return queryResponse.Value, nil
+	newTxLogger(stub).Debugf("- queryByTxIDAndOperationType queryResult:%s", redactRecord(queryResult))
+	return queryResult, nil
 }
 
 // ============================================================================================================================
@@ -971,6 +1509,10 @@ func (t *AdChainChaincode) PanelUpdate(stub shim.ChaincodeStubInterface) pb.Resp
 	//-------------6 parameters------------
 	//     0       		1       	        2		 	                   3			                 4
 	//   "TxID"	    "IsFinished"  "Tag|Field|LineCount|HLL"     "Tag|Field|LineCount|HLL"       ...(add as many as we have)
+	//The HLL kept off-chain is appended as artifact: "Tag|Field|LineCount|HLL|Hash|Size|MediaType|URI"
+	//-------------transient map(optional)------------
+	// "hll_<tag>_<field>": like hll_gender_male, sent instead of the HLL in argument, it is encrypted to the sponsor.
+	// "encryptionKey", "encryptionNonce": the secret randomness of the encryption, necessary with "hll_<tag>_<field>", see EncryptedPayload.
 	//Because the Paneling request might be triggered by the same Sponsor with same Data together with Same Providers multiple times. So TxID is the unique ID.
 
 	// ==== Input sanitation ====
@@ -989,6 +1531,11 @@ func (t *AdChainChaincode) PanelUpdate(stub shim.ChaincodeStubInterface) pb.Resp
 		return shim.Error(err.Error())
 	}
 
+	err = checkOrgActive(stub, providerId, "Current owner")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	txID := args[0]
 	isFinished, err := strconv.ParseBool(args[1])
 	if err != nil {
@@ -1012,7 +1559,7 @@ func (t *AdChainChaincode) PanelUpdate(stub shim.ChaincodeStubInterface) pb.Resp
 			return shim.Error(fmt.Sprintf("This Paneling action already finished before, txID:%s", dataJSON.TxID))
 		}
 	} else {
-		fmt.Sprintf("Paneling data with TxID:%s doesn't exist, please do PanelRequest first.\n", txID)
+		newTxLogger(stub).Warningf("Paneling data with TxID:%s doesn't exist, please do PanelRequest first.", txID)
 		return shim.Error(fmt.Sprintf("Paneling data with TxID:%s doesn't exist, please do PanelRequest first.", txID))
 	}
 
@@ -1024,15 +1571,22 @@ func (t *AdChainChaincode) PanelUpdate(stub shim.ChaincodeStubInterface) pb.Resp
 	}
 
 	if genderProvider_P == nil {
-		fmt.Sprintf("Current owner:%s is not a provider in Paneling data which has txID:%s.\n", providerId, txID)
+		newTxLogger(stub).Warningf("Current owner:%s is not a provider in Paneling data which has txID:%s.", redactOwner(providerId), txID)
 		return shim.Error(fmt.Sprintf("Current owner:%s is not a provider in Paneling data which has txID:%s.", providerId, txID))
 	}
 
 	for i := 2; i < len(args); i++ {
-		list := strings.Split(args[i], "|")
-		if len(list) != 4 {
+		list := strings.SplitN(args[i], "|", 5)
+		if len(list) < 4 {
 			return shim.Error(strconv.Itoa(i) + "th argument must be combined by: Tag|Field|LineCount|HLL")
 		}
+		var hllArtifact *ArtifactRef
+		if len(list) == 5 {
+			hllArtifact, err = parseArtifactRef(list[4])
+			if err != nil {
+				return shim.Error(strconv.Itoa(i) + "th argument is invalid: " + err.Error())
+			}
+		}
 		tag := strings.ToLower(list[0])
 		field := strings.ToLower(list[1])
 		lineCount, err := strconv.Atoi(list[2])
@@ -1040,14 +1594,32 @@ func (t *AdChainChaincode) PanelUpdate(stub shim.ChaincodeStubInterface) pb.Resp
 			return shim.Error(strconv.Itoa(i) + "th argument must contain a numeric string as lineCount.")
 		}
 		hll := strings.ToLower(list[3])
+
+		//the HLL sent in the transient map is encrypted to the sponsor, the HLL in argument should be empty then.
+		var encryptedHLL *EncryptedPayload
+		label := "hll_" + tag + "_" + field
+		sketch, err := getTransientPayload(stub, label)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if sketch != nil {
+			if len(hll) > 0 {
+				return shim.Error(strconv.Itoa(i) + "th argument must have an empty HLL when " + label + " is sent in the transient map.")
+			}
+			encryptedHLL, err = encryptForOwner(stub, dataJSON.Sponsor, label, sketch)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+
 		if tag == "gender" {
 			switch field {
 			case "male":
-				genderProvider_P.Gender.Male = DataDigest{lineCount, hll}
+				genderProvider_P.Gender.Male = DataDigest{lineCount, hll, encryptedHLL, hllArtifact}
 			case "female":
-				genderProvider_P.Gender.Female = DataDigest{lineCount, hll}
+				genderProvider_P.Gender.Female = DataDigest{lineCount, hll, encryptedHLL, hllArtifact}
 			case "all":
-				genderProvider_P.Gender.All = DataDigest{lineCount, hll}
+				genderProvider_P.Gender.All = DataDigest{lineCount, hll, encryptedHLL, hllArtifact}
 			default:
 				//should not go here
 				return shim.Error(strconv.Itoa(i) + "th argument must contain an existing field name.")
@@ -1074,7 +1646,11 @@ func (t *AdChainChaincode) PanelUpdate(stub shim.ChaincodeStubInterface) pb.Resp
 
 	// === Save data to state ===
 	key := "PanelRequest" + "_" + txID
-	fmt.Printf("Starting PutState, key:%s, value:%s\n", key, string(dataJSONasBytes))
+	dataJSONasBytes, err = stampWriter(stub, dataJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(key), redactRecord(dataJSONasBytes))
 	err = stub.PutState(key, dataJSONasBytes)
 	if err != nil {
 		return shim.Error(err.Error())
@@ -1086,6 +1662,6 @@ func (t *AdChainChaincode) PanelUpdate(stub shim.ChaincodeStubInterface) pb.Resp
 func main() {
 	err := shim.Start(new(AdChainChaincode))
 	if err != nil {
-		fmt.Printf("Error starting AdChainChaincode: %s", err)
+		logger.Criticalf("Error starting AdChainChaincode: %s", err)
 	}
 }
\ No newline at end of file
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Artifact reference schema binds a large file kept off-chain(like a Bloom on IPFS) to the record by its content hash.
+// It is passed in arguments as "Hash|Size|MediaType|URI", the hash is the hex of sha256 with an optional "sha256:" prefix.
+type ArtifactRef struct {
+	Hash      string `json:"hash"`      //hex of sha256 of the file content
+	Size      int64  `json:"size"`      //size of the file in bytes
+	MediaType string `json:"mediaType"` //like application/octet-stream
+	URI       string `json:"uri"`       //where the file can be downloaded, like ipfs://<cid> or https://...
+}
+
+// Verify artifact result schema is returned to the client by VerifyArtifact.
+type VerifyArtifactResult struct {
+	Key       string                  `json:"key"`
+	Hash      string                  `json:"hash"`                //the hash given by the client
+	Verified  bool                    `json:"verified"`            //true if one of the artifacts of the record has the hash
+	Field     string                  `json:"field,omitempty"`     //path of the matched artifact, like bloomArtifact
+	Artifacts map[string]*ArtifactRef `json:"artifacts,omitempty"` //all the artifacts committed on the record, by path
+}
+
+const artifactHashPrefix = "sha256:"
+
+// ========================================================
+// normalizeArtifactHash returns the lower case hex of a sha256, the "sha256:" prefix is removed.
+// ========================================================
+func normalizeArtifactHash(hash string) (string, error) {
+	hash = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(hash)), artifactHashPrefix)
+	decoded, err := hex.DecodeString(hash)
+	if err != nil || len(decoded) != 32 {
+		return "", errors.New(fmt.Sprintf("The hash:%s must be the hex of sha256.", hash))
+	}
+	return hash, nil
+}
+
+// ========================================================
+// parseArtifactRef parses "Hash|Size|MediaType|URI", the URI is the last part so it may contain '|'.
+// An empty value means no artifact, nil is returned then.
+// ========================================================
+func parseArtifactRef(value string) (*ArtifactRef, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+	list := strings.SplitN(value, "|", 4)
+	if len(list) != 4 {
+		return nil, errors.New("The artifact must be combined by: Hash|Size|MediaType|URI")
+	}
+	hash, err := normalizeArtifactHash(list[0])
+	if err != nil {
+		return nil, err
+	}
+	size, err := strconv.ParseInt(list[1], 10, 64)
+	if err != nil || size < 0 {
+		return nil, errors.New(fmt.Sprintf("The size:%s of artifact must be a non-negative numeric string.", list[1]))
+	}
+	if len(list[2]) == 0 {
+		return nil, errors.New("The media type of artifact must be a non-empty string.")
+	}
+	uri, err := url.Parse(list[3])
+	if err != nil || len(uri.Scheme) == 0 {
+		return nil, errors.New(fmt.Sprintf("The URI:%s of artifact must be an absolute URI.", list[3]))
+	}
+	return &ArtifactRef{hash, size, strings.ToLower(list[2]), list[3]}, nil
+}
+
+// ============================================================================================================================
+// VerifyArtifact - query whether the hash of a downloaded file equals one of the artifacts committed on the record,
+// so a counterparty can prove the file is the one the owner registered.
+// ============================================================================================================================
+func (t *AdChainChaincode) VerifyArtifact(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	//-------------2 parameters------------
+	//    0       1
+	//  "Key"  "Hash"
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 parameters for VerifyArtifact")
+	}
+	for i := 0; i < 2; i++ {
+		if len(args[i]) <= 0 {
+			return shim.Error(strconv.Itoa(i) + "th argument must be a non-empty string")
+		}
+	}
+	key := args[0]
+	hash, err := normalizeArtifactHash(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	value, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if value == nil {
+		return shim.Error(fmt.Sprintf("The key:%s doesn't exist.", key))
+	}
+	value, _, err = upgradeRecord(value)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var record map[string]interface{}
+	err = json.Unmarshal(value, &record)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("The key:%s is not a record of adchain.", key))
+	}
+
+	artifacts := map[string]*ArtifactRef{}
+	collectArtifacts(record, "", artifacts)
+	if len(artifacts) == 0 {
+		return shim.Error(fmt.Sprintf("The key:%s doesn't have any artifact.", key))
+	}
+
+	result := VerifyArtifactResult{key, hash, false, "", artifacts}
+	paths := make([]string, 0, len(artifacts))
+	for path := range artifacts {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if artifacts[path].Hash == hash {
+			result.Verified = true
+			result.Field = path
+			break
+		}
+	}
+
+	resultJSONasBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultJSONasBytes)
+}
+
+// ========================================================
+// collectArtifacts walks the record and collects every field named like "*Artifact" by its path,
+// like bloomArtifact or providers.genderProviderArray[0].gender.male.hllArtifact.
+// ========================================================
+func collectArtifacts(value interface{}, path string, artifacts map[string]*ArtifactRef) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for field, fieldValue := range v {
+			fieldPath := field
+			if len(path) > 0 {
+				fieldPath = path + "." + field
+			}
+			if strings.HasSuffix(field, "Artifact") {
+				if artifact := toArtifactRef(fieldValue); artifact != nil {
+					artifacts[fieldPath] = artifact
+				}
+				continue
+			}
+			collectArtifacts(fieldValue, fieldPath, artifacts)
+		}
+	case []interface{}:
+		for i, item := range v {
+			collectArtifacts(item, fmt.Sprintf("%s[%d]", path, i), artifacts)
+		}
+	}
+}
+
+func toArtifactRef(value interface{}) *ArtifactRef {
+	if value == nil {
+		return nil
+	}
+	valueJSONasBytes, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	var artifact ArtifactRef
+	err = json.Unmarshal(valueJSONasBytes, &artifact)
+	if err != nil || len(artifact.Hash) == 0 {
+		return nil
+	}
+	return &artifact
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	pb_timestamp "github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Step attestation schema keeps the countersignature of the party which did not submit the step.
+// The counterparty signs sha256 of the message built by attestationMessage with the private key of its registered cert,
+// the signature is the base64 of the ASN.1 DER encoded ECDSA signature(r, s).
+type StepAttestation struct {
+	Signer    string                 `json:"signer"`    //ownerId of the counterparty
+	Signature string                 `json:"signature"` //base64 of the ECDSA signature
+	Timestamp pb_timestamp.Timestamp `json:"timestamp"` //the time when the step was countersigned
+}
+
+// Step dispute schema is raised by the party which did not submit the step, when it does not agree with the reported result.
+type StepDispute struct {
+	Raiser    string                 `json:"raiser"` //ownerId of the counterparty
+	Reason    string                 `json:"reason"`
+	Timestamp pb_timestamp.Timestamp `json:"timestamp"` //the time when the step was disputed
+}
+
+// ========================================================
+// attestationMessage is what the counterparty signs, every field of the reported result is covered:
+//
+//	OnBoarding|<txID>|<step>|<actingParty>|<owner>|<dataName>|<targetOwner>|<targetDataName>|<filteredLineCount>|<isFinished>
+//
+// ========================================================
+func attestationMessage(record *OnBoarding) []byte {
+	return []byte(strings.Join([]string{"OnBoarding",
+		record.TxID,
+		strconv.Itoa(record.Step),
+		record.ActingParty,
+		record.Owner,
+		record.DataName,
+		record.TargetOwner,
+		record.TargetDataName,
+		strconv.Itoa(record.FilteredLineCount),
+		strconv.FormatBool(record.IsFinished)}, "|"))
+}
+
+// ========================================================
+// checkActingParty makes sure the party named by the step is one of the parties of the session,
+// and the submitter's cert belongs to it.
+// ========================================================
+func checkActingParty(stub shim.ChaincodeStubInterface, actingParty string, ownerId string, targetOwner string) error {
+	if actingParty != ownerId && actingParty != targetOwner {
+		return errors.New(fmt.Sprintf("The acting party:%s is neither the owner nor the targetOwner of the OnBoarding.", actingParty))
+	}
+	submitterId, err := generateOwnerIdByCert(stub)
+	if err != nil {
+		return err
+	}
+	if submitterId != actingParty {
+		return errors.New(fmt.Sprintf("Current ownerId:%s does not equal to the acting party:%s in argument.", submitterId, actingParty))
+	}
+	return nil
+}
+
+// ========================================================
+// verifyOwnerSignature checks the ECDSA signature of the message against the public key the owner registered.
+// ========================================================
+func verifyOwnerSignature(stub shim.ChaincodeStubInterface, ownerId string, message []byte, signature string) error {
+	publicKey, err := getRegisteredPublicKey(stub, ownerId)
+	if err != nil {
+		return err
+	}
+	signatureBytes, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return errors.New("The signature must be a base64 string.")
+	}
+	var rs struct {
+		R, S *big.Int
+	}
+	rest, err := asn1.Unmarshal(signatureBytes, &rs)
+	if err != nil || len(rest) > 0 {
+		return errors.New("The signature must be an ASN.1 DER encoded ECDSA signature.")
+	}
+	digest := sha256.Sum256(message)
+	if !ecdsa.Verify(publicKey, digest[:], rs.R, rs.S) {
+		return errors.New(fmt.Sprintf("The signature does not match the registered public key of owner:%s.", ownerId))
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// OnBoardingAttest is called by the counterparty of the latest step to countersign the reported result.
+// A step can only be countersigned once, the attestation of a finished step makes the result mutually attested.
+// ============================================================================================================================
+func (t *AdChainChaincode) OnBoardingAttest(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	//-------------3 parameters------------
+	//     0       1          2
+	//   "TxID"  "Step"  "Signature"
+
+	dataJSON, counterparty, err := getStepToCountersign(stub, args, "OnBoardingAttest")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	signature := args[2]
+	err = verifyOwnerSignature(stub, counterparty, attestationMessage(dataJSON), signature)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	dataJSON.Attestation = &StepAttestation{counterparty, signature, txTimestamp}
+
+	err = putOnBoarding(stub, dataJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================================================================================
+// OnBoardingDispute is called by the counterparty of the latest step instead of OnBoardingAttest, when it does not agree with
+// the reported result. The dispute counts against the reputation of the acting party.
+// ============================================================================================================================
+func (t *AdChainChaincode) OnBoardingDispute(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	//-------------3 parameters------------
+	//     0       1          2
+	//   "TxID"  "Step"  "Reason"
+
+	dataJSON, counterparty, err := getStepToCountersign(stub, args, "OnBoardingDispute")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	dataJSON.Dispute = &StepDispute{counterparty, args[2], txTimestamp}
+
+	err = putOnBoarding(stub, dataJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	deltas := reputationDeltas{}
+	deltas.of(dataJSON.ActingParty).DisputesAgainst++
+	deltas.of(counterparty).DisputesRaised++
+	err = applyReputationDeltas(stub, deltas)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ========================================================
+// getStepToCountersign checks the arguments "TxID" "Step" "Signature or Reason", and returns the latest step of the OnBoarding
+// with its counterparty, which must be current owner. A step is either countersigned or disputed, only once.
+// ========================================================
+func getStepToCountersign(stub shim.ChaincodeStubInterface, args []string, function string) (*OnBoarding, string, error) {
+	if len(args) != 3 {
+		return nil, "", errors.New(fmt.Sprintf("Incorrect number of arguments. Expecting 3 parameters for %s", function))
+	}
+	for i := 0; i < 3; i++ {
+		if len(args[i]) <= 0 {
+			return nil, "", errors.New(strconv.Itoa(i) + "th argument must be a non-empty string")
+		}
+	}
+	txID := args[0]
+	step, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, "", errors.New(fmt.Sprintf("2nd argument must be a numeric string as step of %s.", function))
+	}
+
+	queryResult, err := queryByTxIDAndOperationType(stub, "OnBoarding", txID)
+	if err != nil {
+		return nil, "", err
+	}
+	if queryResult == nil || len(queryResult) == 0 {
+		return nil, "", errors.New(fmt.Sprintf("OnBoarding with TxID:%s doesn't exist.", txID))
+	}
+	var dataJSON OnBoarding
+	err = json.Unmarshal(queryResult, &dataJSON)
+	if err != nil {
+		return nil, "", err
+	}
+
+	//only the latest step is kept on the record, the earlier steps can be found by GetRecordHistory.
+	if dataJSON.Step != step {
+		return nil, "", errors.New(fmt.Sprintf("The latest step of OnBoarding txID:%s is %d, can not countersign step:%d.", txID, dataJSON.Step, step))
+	}
+	if len(dataJSON.ActingParty) == 0 {
+		return nil, "", errors.New(fmt.Sprintf("The step:%d of OnBoarding txID:%s has no acting party, can not be countersigned.", step, txID))
+	}
+	if dataJSON.Attestation != nil {
+		return nil, "", errors.New(fmt.Sprintf("The step:%d of OnBoarding txID:%s is already countersigned by:%s.", step, txID, dataJSON.Attestation.Signer))
+	}
+	if dataJSON.Dispute != nil {
+		return nil, "", errors.New(fmt.Sprintf("The step:%d of OnBoarding txID:%s is already disputed by:%s.", step, txID, dataJSON.Dispute.Raiser))
+	}
+
+	counterparty := dataJSON.Owner
+	if dataJSON.ActingParty == dataJSON.Owner {
+		counterparty = dataJSON.TargetOwner
+	}
+	submitterId, err := generateOwnerIdByCert(stub)
+	if err != nil {
+		return nil, "", err
+	}
+	if submitterId != counterparty {
+		return nil, "", errors.New(fmt.Sprintf("Current owner:%s is not the counterparty of the step:%d, can not countersign it.", submitterId, step))
+	}
+	err = checkOrgActive(stub, counterparty, "Current owner")
+	if err != nil {
+		return nil, "", err
+	}
+	return &dataJSON, counterparty, nil
+}
+
+func putOnBoarding(stub shim.ChaincodeStubInterface, dataJSON *OnBoarding) error {
+	dataJSONasBytes, err := json.Marshal(dataJSON)
+	if err != nil {
+		return err
+	}
+	key := "OnBoarding" + "_" + dataJSON.TxID
+	dataJSONasBytes, err = stampWriter(stub, dataJSONasBytes)
+	if err != nil {
+		return err
+	}
+	newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(key), redactRecord(dataJSONasBytes))
+	return stub.PutState(key, dataJSONasBytes)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	pb_timestamp "github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Chaincode config schema is returned to the client by GetConfig, it is not stored on chain.
+// Each part keeps its own record: Init writes them. The admin orgs, quotas and quorum are changed by the governance
+// actions(SetQuorum, SetRoleOrgs, SetQuota) so that no single org can change them, the policy by the admins with UpdateConfig.
+type ChaincodeConfig struct {
+	Governance    GovernanceConfig `json:"governance"`
+	Roles         RolesConfig      `json:"roles"`
+	Registry      RegistryConfig   `json:"registry"`
+	Quota         QuotaConfig      `json:"quota"`
+	Logging       LoggingConfig    `json:"logging"`
+	Policy        PolicyConfig     `json:"policy"`
+	OwnerIdLength int              `json:"ownerIdLength"` //always ownerIdLength, for the clients which validate ownerIds
+}
+
+// Policy config schema is the config document of what DataRegister and PanelRequest accept, and of the functions switched off.
+// It is validated and written by Init, and replaced by the admins with UpdateConfig.
+// To store this data the key will be: "PolicyConfig"
+type PolicyConfig struct {
+	Version          int                    `json:"version"`          //increased by every UpdateConfig
+	AllowedDataTypes []string               `json:"allowedDataTypes"` //dataTypes accepted by DataRegister, empty means any
+	AllowedTags      []string               `json:"allowedTags"`      //panel tags accepted by DataRegister and PanelRequest, empty means any of supportedTags
+	DataExpiry       string                 `json:"dataExpiry"`       //default expiry of DataRegister as a duration like 720h, empty means never
+	Features         map[string]bool        `json:"features"`         //function => enabled, functions not listed are enabled
+	UpdatedBy        string                 `json:"updatedBy"`        //MSP id of the last writer
+	Timestamp        pb_timestamp.Timestamp `json:"timestamp"`
+}
+
+const policyConfigKey = "PolicyConfig"
+
+const ownerIdLength = 32
+
+// supportedTags are the panel tags Providers has a field for.
+var supportedTags = []string{"gender"}
+
+// fixedFunctions can never be switched off, so the config and the governance stay usable.
+var fixedFunctions = []string{"UpdateConfig", "GetConfig", "Propose", "Vote", "GetProposal", "ListProposals"}
+
+// defaultPolicyConfig is written by Init when no config document is given, and fills the fields a document leaves out.
+func defaultPolicyConfig() PolicyConfig {
+	return PolicyConfig{0, []string{}, []string{}, "", map[string]bool{}, "", pb_timestamp.Timestamp{}}
+}
+
+// ========================================================
+// initPolicyConfig is called by Init with the optional "Config" argument. Without a document an existing config is kept,
+// so an upgrade does not reset it. An upgrade can not replace an existing config with a document, that is left to UpdateConfig.
+// ========================================================
+func initPolicyConfig(stub shim.ChaincodeStubInterface, document string) error {
+	mspId, err := getMspId(stub)
+	if err != nil {
+		return err
+	}
+	existing, err := stub.GetState(policyConfigKey)
+	if err != nil {
+		return err
+	}
+	if len(document) == 0 {
+		if existing != nil {
+			return nil
+		}
+		return putPolicyConfig(stub, defaultPolicyConfig(), mspId)
+	}
+	if existing != nil {
+		return errors.New("The chaincode already has a config, upgrade without the Config argument and change it with UpdateConfig.")
+	}
+
+	config, err := parsePolicyConfig(document)
+	if err != nil {
+		return err
+	}
+	return putPolicyConfig(stub, config, mspId)
+}
+
+func getPolicyConfig(stub shim.ChaincodeStubInterface) (PolicyConfig, error) {
+	config := defaultPolicyConfig()
+	configJSONasBytes, err := stub.GetState(policyConfigKey)
+	if err != nil {
+		return config, err
+	}
+	if configJSONasBytes != nil {
+		err = json.Unmarshal(configJSONasBytes, &config)
+	}
+	return config, err
+}
+
+// ========================================================
+// parsePolicyConfig unmarshals and validates a config document, the fields left out get the default values.
+// ========================================================
+func parsePolicyConfig(document string) (PolicyConfig, error) {
+	config := defaultPolicyConfig()
+	decoder := json.NewDecoder(strings.NewReader(document))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&config)
+	if err != nil {
+		return config, errors.New(fmt.Sprintf("The config document must be a JSON object of PolicyConfig, err:%s", err))
+	}
+
+	for i, dataType := range config.AllowedDataTypes {
+		if len(dataType) == 0 {
+			return config, errors.New("The allowed dataTypes must be non-empty strings.")
+		}
+		config.AllowedDataTypes[i] = strings.ToLower(dataType)
+	}
+	for i, tag := range config.AllowedTags {
+		config.AllowedTags[i] = strings.ToLower(tag)
+		if !containsString(supportedTags, config.AllowedTags[i]) {
+			return config, errors.New(fmt.Sprintf("The tag:%s is not supported, expecting one of %s.", tag, strings.Join(supportedTags, ", ")))
+		}
+	}
+	if len(config.DataExpiry) > 0 {
+		expiry, err := time.ParseDuration(config.DataExpiry)
+		if err != nil || expiry <= 0 {
+			return config, errors.New(fmt.Sprintf("The dataExpiry:%s must be a positive duration like 720h.", config.DataExpiry))
+		}
+	}
+	for function := range config.Features {
+		if _, ok := functionRoles[function]; !ok || containsString(fixedFunctions, function) {
+			return config, errors.New(fmt.Sprintf("The function:%s can not be switched, the functions %s are always enabled.", function, strings.Join(fixedFunctions, ", ")))
+		}
+	}
+	return config, nil
+}
+
+func putPolicyConfig(stub shim.ChaincodeStubInterface, config PolicyConfig, updatedBy string) error {
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	config.UpdatedBy = updatedBy
+	config.Timestamp = txTimestamp
+	configJSONasBytes, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", policyConfigKey, configJSONasBytes)
+	return stub.PutState(policyConfigKey, configJSONasBytes)
+}
+
+// ============================================================================================================================
+// UpdateConfig - replace the policy config document, only the admins can do it. The expectedVersion guards against
+// overwriting a change made by another admin in the meantime.
+// ============================================================================================================================
+func (t *AdChainChaincode) UpdateConfig(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	//-------------2 parameters------------
+	//     0           1
+	//   "Config"  "ExpectedVersion"
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 parameters for UpdateConfig")
+	}
+	mspId, err := getMspId(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	current, err := getPolicyConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[1] != fmt.Sprintf("%d", current.Version) {
+		return shim.Error(fmt.Sprintf("Config version conflict, expected version:%s, current version:%d.", args[1], current.Version))
+	}
+
+	config, err := parsePolicyConfig(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	config.Version = current.Version + 1
+	err = putPolicyConfig(stub, config, mspId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	newTxLogger(stub).Infof("UpdateConfig applied, version:%d, updated by %s", config.Version, mspId)
+	return shim.Success([]byte(fmt.Sprintf("%d", config.Version)))
+}
+
+// ============================================================================================================================
+// GetConfig - query the config records of the chaincode in one document.
+// ============================================================================================================================
+func (t *AdChainChaincode) GetConfig(stub shim.ChaincodeStubInterface) pb.Response {
+	var config ChaincodeConfig
+	var err error
+	config.Governance, err = getGovernanceConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	config.Roles, err = getRolesConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	config.Registry, err = getRegistryConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	config.Quota, err = getQuotaConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	config.Logging = defaultLoggingConfig
+	loggingJSONasBytes, err := stub.GetState(loggingConfigKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if loggingJSONasBytes != nil {
+		err = json.Unmarshal(loggingJSONasBytes, &config.Logging)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	config.Policy, err = getPolicyConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	config.OwnerIdLength = ownerIdLength
+
+	configJSONasBytes, err := json.Marshal(config)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(configJSONasBytes)
+}
+
+// ========================================================
+// checkOwnerId checks the length of an ownerId.
+// ========================================================
+func checkOwnerId(field string, ownerId string) error {
+	if len(ownerId) != ownerIdLength {
+		return errors.New(fmt.Sprintf("Incorrect %s. Expecting 16 bytes of md5 hash which has len == %d of hex string.", field, ownerIdLength))
+	}
+	return nil
+}
+
+// ========================================================
+// checkFunctionEnabled returns error if the function is switched off by the policy config.
+// ========================================================
+func checkFunctionEnabled(stub shim.ChaincodeStubInterface, function string) error {
+	config, err := getPolicyConfig(stub)
+	if err != nil {
+		return err
+	}
+	if enabled, ok := config.Features[function]; ok && !enabled {
+		return errors.New(fmt.Sprintf("Function %s is switched off by the config.", function))
+	}
+	return nil
+}
+
+// ========================================================
+// checkDataType checks a dataType against the allowed dataTypes of the policy config.
+// ========================================================
+func checkDataType(config PolicyConfig, dataType string) error {
+	allowed := config.AllowedDataTypes
+	if len(allowed) > 0 && !containsString(allowed, dataType) {
+		return errors.New(fmt.Sprintf("The dataType:%s is not allowed, expecting one of %s.", dataType, strings.Join(allowed, ", ")))
+	}
+	return nil
+}
+
+// ========================================================
+// checkTag checks a panel tag against the supported tags and the allowed tags of the policy config.
+// ========================================================
+func checkTag(config PolicyConfig, tag string) error {
+	allowed := config.AllowedTags
+	if len(allowed) == 0 {
+		allowed = supportedTags
+	}
+	if !containsString(allowed, tag) {
+		return errors.New(fmt.Sprintf("Current tag:%s is not allowed, expecting one of %s.", tag, strings.Join(allowed, ", ")))
+	}
+	return nil
+}
+
+// ========================================================
+// defaultDataExpiry returns the expiry of data registered without one, 0 means never expires.
+// ========================================================
+func defaultDataExpiry(config PolicyConfig, txTimestamp pb_timestamp.Timestamp) pb_timestamp.Timestamp {
+	if len(config.DataExpiry) == 0 {
+		return pb_timestamp.Timestamp{0, 0}
+	}
+	expiry, err := parseDeadline(txTimestamp, config.DataExpiry)
+	if err != nil {
+		return pb_timestamp.Timestamp{0, 0}
+	}
+	return expiry
+}
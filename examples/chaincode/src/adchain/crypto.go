@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Encrypted payload schema keeps a sensitive sketch(HLL or Bloom) which only the recipient can decrypt.
+//
+// The payload is encrypted with ECIES on the curve of the recipient's registered public key:
+//
+//	shared = X coordinate of (ephemeralPrivateKey * recipientPublicKey), left padded to the curve size
+//	key    = sha256(shared)
+//	ciphertext = AES-256-GCM(key, nonce, plaintext), no additional data
+//
+// The recipient decrypts with sha256(X of (recipientPrivateKey * ephemeralPublicKey)), then checks the hash.
+//
+// All endorsers must produce the same write set, so the chaincode can not draw random numbers itself. The client sends the
+// randomness in the transient map, which is never written to the ledger:
+//
+//	encryptionKey   secret random seed of at least 32 bytes, ephemeralPrivateKey = HMAC-SHA256(encryptionKey, label)
+//	encryptionNonce random GCM nonce of 12 bytes
+//
+// Each label gets its own ephemeral key, so the payloads of a transaction never share a key and nonce.
+type EncryptedPayload struct {
+	Recipient          string `json:"recipient"`          //ownerId whose registered public key is used
+	EphemeralPublicKey string `json:"ephemeralPublicKey"` //base64 of the uncompressed ephemeral public point
+	Nonce              string `json:"nonce"`              //base64 of the GCM nonce
+	Ciphertext         string `json:"ciphertext"`         //base64 of the GCM ciphertext
+	Hash               string `json:"hash"`               //hex of sha256(plaintext) for integrity check after decryption
+}
+
+const minEncryptionKeySize = 32
+
+const encryptionNonceSize = 12
+
+// ========================================================
+// getPublicKeyPEM returns the public key of the creator cert in PEM format, it is registered by OrgRegister.
+// ========================================================
+func getPublicKeyPEM(stub shim.ChaincodeStubInterface) (string, error) {
+	cert, err := getX509Cert(stub)
+	if err != nil {
+		return "", err
+	}
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyBytes})), nil
+}
+
+// ========================================================
+// getTransientPayload returns the payload sent in the transient map, nil if it is not sent.
+// ========================================================
+func getTransientPayload(stub shim.ChaincodeStubInterface, name string) ([]byte, error) {
+	transientMap, err := stub.GetTransient()
+	if err != nil {
+		return nil, err
+	}
+	payload, ok := transientMap[name]
+	if !ok || len(payload) == 0 {
+		return nil, nil
+	}
+	return payload, nil
+}
+
+// ========================================================
+// encryptForOwner encrypts the payload to the registered public key of the recipient.
+// The label must be unique within the transaction, like "hll" or "hll_gender_male".
+// ========================================================
+func encryptForOwner(stub shim.ChaincodeStubInterface, recipient string, label string, plaintext []byte) (*EncryptedPayload, error) {
+	publicKey, err := getRegisteredPublicKey(stub, recipient)
+	if err != nil {
+		return nil, err
+	}
+	seed, nonce, err := getEncryptionSecrets(stub)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := encryptPayload(publicKey, seed, nonce, label, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	payload.Recipient = recipient
+	return payload, nil
+}
+
+// ========================================================
+// getRegisteredPublicKey returns the ECDSA public key the owner registered by OrgRegister.
+// ========================================================
+func getRegisteredPublicKey(stub shim.ChaincodeStubInterface, ownerId string) (*ecdsa.PublicKey, error) {
+	org, _, err := getOrgRegistering(stub, ownerId)
+	if err != nil {
+		return nil, err
+	}
+	if org == nil {
+		return nil, errors.New(fmt.Sprintf("The owner:%s has not registered yet, please do OrgRegister first.", ownerId))
+	}
+	if len(org.PublicKey) == 0 {
+		return nil, errors.New(fmt.Sprintf("The owner:%s has not registered the public key yet, please do OrgRegister again.", ownerId))
+	}
+
+	block, _ := pem.Decode([]byte(org.PublicKey))
+	if block == nil {
+		return nil, errors.New(fmt.Sprintf("Failed to parse public key PEM of owner:%s", ownerId))
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ecdsaPublicKey, ok := publicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("The public key of owner:%s is not an ECDSA key.", ownerId))
+	}
+	return ecdsaPublicKey, nil
+}
+
+// ========================================================
+// getEncryptionSecrets returns the encryptionKey and encryptionNonce sent in the transient map.
+// ========================================================
+func getEncryptionSecrets(stub shim.ChaincodeStubInterface) ([]byte, []byte, error) {
+	seed, err := getTransientPayload(stub, "encryptionKey")
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(seed) < minEncryptionKeySize {
+		return nil, nil, errors.New(fmt.Sprintf("The transient encryptionKey must be a secret random seed of at least %d bytes.", minEncryptionKeySize))
+	}
+	nonce, err := getTransientPayload(stub, "encryptionNonce")
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(nonce) != encryptionNonceSize {
+		return nil, nil, errors.New(fmt.Sprintf("The transient encryptionNonce must be %d random bytes.", encryptionNonceSize))
+	}
+	return seed, nonce, nil
+}
+
+func encryptPayload(publicKey *ecdsa.PublicKey, seed []byte, nonce []byte, label string, plaintext []byte) (*EncryptedPayload, error) {
+	curve := publicKey.Curve
+	hash := sha256.Sum256(plaintext)
+
+	ephemeralPrivateKey := deriveScalar(curve, seed, label)
+	ephemeralX, ephemeralY := curve.ScalarBaseMult(ephemeralPrivateKey)
+	sharedX, _ := curve.ScalarMult(publicKey.X, publicKey.Y, ephemeralPrivateKey)
+
+	shared := make([]byte, (curve.Params().BitSize+7)/8)
+	sharedBytes := sharedX.Bytes()
+	copy(shared[len(shared)-len(sharedBytes):], sharedBytes)
+	key := sha256.Sum256(shared)
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext := gcm.Seal(nil, nonce, plaintext, nil)
+
+	return &EncryptedPayload{"",
+		base64.StdEncoding.EncodeToString(elliptic.Marshal(curve, ephemeralX, ephemeralY)),
+		base64.StdEncoding.EncodeToString(nonce),
+		base64.StdEncoding.EncodeToString(ciphertext),
+		hex.EncodeToString(hash[:])}, nil
+}
+
+// deriveScalar returns a private key in [1, N-1] derived from the secret seed and the label.
+func deriveScalar(curve elliptic.Curve, seed []byte, label string) []byte {
+	mac := hmac.New(sha256.New, seed)
+	mac.Write([]byte(label))
+	k := new(big.Int).SetBytes(mac.Sum(nil))
+	n := new(big.Int).Sub(curve.Params().N, big.NewInt(1))
+	k.Mod(k, n)
+	k.Add(k, big.NewInt(1))
+	return k.Bytes()
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	pb_timestamp "github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Dataset descriptor schema is one item of the DataRegisterBatch argument, the fields are the same as the DataRegister arguments.
+type DatasetDescriptor struct {
+	DataType      string `json:"dataType"`
+	DataName      string `json:"dataName"`
+	LineCount     int    `json:"lineCount"`
+	HLL           string `json:"hll"`
+	Bloom         string `json:"bloom"`
+	Tag           string `json:"tag"`
+	Field         string `json:"field"`
+	Expiry        string `json:"expiry"`        //720h or RFC3339, empty means the dataExpiry of PolicyConfig
+	HLLArtifact   string `json:"hllArtifact"`   //Hash|Size|MediaType|URI
+	BloomArtifact string `json:"bloomArtifact"` //Hash|Size|MediaType|URI
+}
+
+// Batch item result schema is returned to the client by DataRegisterBatch, one for each descriptor in the same order.
+type BatchItemResult struct {
+	Index    int    `json:"index"`
+	DataName string `json:"dataName"`
+	Status   string `json:"status"`           //one of: registered; already-exists; invalid; skipped
+	Reason   string `json:"reason,omitempty"` //why the item is invalid
+}
+
+// Batch error schema is the message of the error returned when any descriptor is invalid, so the client can parse
+// the per-item results from it: {"error":"2 of 5 dataset descriptors are invalid, nothing is registered","results":[...]}
+type BatchError struct {
+	Error   string            `json:"error"`
+	Results []BatchItemResult `json:"results"`
+}
+
+const (
+	batchStatusRegistered    = "registered"
+	batchStatusAlreadyExists = "already-exists"
+	batchStatusInvalid       = "invalid"
+	batchStatusSkipped       = "skipped" //valid, but not registered because another item of the batch is invalid
+)
+
+const maxDataRegisterBatchSize = 500
+
+// ============================================================================================================================
+// DataRegisterBatch registers many data of current owner in one transaction.
+// All the descriptors are validated first, if any of them is invalid nothing is written and the results are returned in the error
+// message as a BatchError JSON.
+// The data already registered before are skipped as DataRegister does.
+// ============================================================================================================================
+func (t *AdChainChaincode) DataRegisterBatch(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	//-------------1 parameter------------
+	//     0
+	//   "Descriptors": JSON array of DatasetDescriptor, like [{"dataType":"imei","dataName":"file1","lineCount":100}]
+	//The sketches can not be sent in the transient map in a batch, use DataRegister for the encrypted ones.
+
+	if len(args) != 1 || len(args[0]) == 0 {
+		return shim.Error("Incorrect number of arguments. Expecting a JSON array of dataset descriptors for DataRegisterBatch")
+	}
+	var descriptors []DatasetDescriptor
+	err := json.Unmarshal([]byte(args[0]), &descriptors)
+	if err != nil {
+		return shim.Error("1st argument must be a JSON array of dataset descriptors, err: " + err.Error())
+	}
+	if len(descriptors) == 0 || len(descriptors) > maxDataRegisterBatchSize {
+		return shim.Error(fmt.Sprintf("1st argument must have 1 to %d dataset descriptors.", maxDataRegisterBatchSize))
+	}
+
+	ownerId, err := generateOwnerIdByCert(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	//a suspended or deregistered owner can not register new data
+	org, _, err := getOrgRegistering(stub, ownerId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if org != nil {
+		err = checkOrgStatus(org, "Current owner")
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	policy, err := getPolicyConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	//one rich query for all the data instead of one for each
+	existing, err := queryRegisteredDataNames(stub, ownerId, descriptors)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	results := make([]BatchItemResult, len(descriptors))
+	records := make([]*DataRegistering, len(descriptors))
+	seen := map[string]bool{}
+	invalid := 0
+	for i, descriptor := range descriptors {
+		results[i] = BatchItemResult{Index: i, DataName: descriptor.DataName}
+		if seen[descriptor.DataName] {
+			results[i].Status = batchStatusInvalid
+			results[i].Reason = fmt.Sprintf("The dataName:%s is duplicated in the batch.", descriptor.DataName)
+			invalid++
+			continue
+		}
+		seen[descriptor.DataName] = true
+
+		records[i], err = newDataRegistering(policy, ownerId, txTimestamp, descriptor)
+		if err != nil {
+			results[i].Status = batchStatusInvalid
+			results[i].Reason = err.Error()
+			invalid++
+			continue
+		}
+		if existing[descriptor.DataName] {
+			results[i].Status = batchStatusAlreadyExists
+			records[i] = nil
+			continue
+		}
+		results[i].Status = batchStatusRegistered
+	}
+
+	if invalid > 0 {
+		for i := range results {
+			if results[i].Status == batchStatusRegistered {
+				results[i].Status = batchStatusSkipped
+			}
+		}
+		batchErrorJSONasBytes, err := json.Marshal(BatchError{
+			fmt.Sprintf("%d of %d dataset descriptors are invalid, nothing is registered", invalid, len(descriptors)), results})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Error(string(batchErrorJSONasBytes))
+	}
+
+	registered := 0
+	for _, record := range records {
+		if record != nil {
+			registered++
+		}
+	}
+	if registered > 0 {
+		err = consumeQuotaCount(stub, ownerId, "DataRegister", registered)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	for _, record := range records {
+		if record == nil {
+			continue
+		}
+		dataJSONasBytes, err := json.Marshal(record)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		key := record.OperationType + "_" + ownerId + "_" + record.DataName
+		dataJSONasBytes, err = stampWriter(stub, dataJSONasBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(key), redactRecord(dataJSONasBytes))
+		err = stub.PutState(key, dataJSONasBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	newTxLogger(stub).Infof("DataRegisterBatch registered %d of %d data", registered, len(descriptors))
+	resultsJSONasBytes, err := json.Marshal(results)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultsJSONasBytes)
+}
+
+// ========================================================
+// newDataRegistering validates the descriptor the same way as DataRegister validates its arguments.
+// ========================================================
+func newDataRegistering(policy PolicyConfig, ownerId string, txTimestamp pb_timestamp.Timestamp, descriptor DatasetDescriptor) (*DataRegistering, error) {
+	if len(descriptor.DataType) == 0 || len(descriptor.DataName) == 0 {
+		return nil, errors.New("dataType and dataName must be non-empty strings.")
+	}
+	if descriptor.LineCount < 0 {
+		return nil, errors.New("lineCount must not be negative.")
+	}
+	err := checkDataType(policy, strings.ToLower(descriptor.DataType))
+	if err != nil {
+		return nil, err
+	}
+	if len(descriptor.Tag) > 0 {
+		err = checkTag(policy, strings.ToLower(descriptor.Tag))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	expiryTimestamp := defaultDataExpiry(policy, txTimestamp)
+	if len(descriptor.Expiry) > 0 {
+		expiryTimestamp, err = parseDeadline(txTimestamp, descriptor.Expiry)
+		if err != nil {
+			return nil, errors.New("expiry must be a duration(like 720h) or a RFC3339 time.")
+		}
+		if !isBefore(txTimestamp, expiryTimestamp) {
+			return nil, errors.New("expiry must be in the future.")
+		}
+	}
+
+	hllArtifact, err := parseArtifactRef(descriptor.HLLArtifact)
+	if err != nil {
+		return nil, errors.New("hllArtifact is invalid: " + err.Error())
+	}
+	bloomArtifact, err := parseArtifactRef(descriptor.BloomArtifact)
+	if err != nil {
+		return nil, errors.New("bloomArtifact is invalid: " + err.Error())
+	}
+
+	return &DataRegistering{"DataRegister",
+		currentSchemaVersion,
+		strings.ToLower(descriptor.DataType),
+		ownerId,
+		descriptor.DataName,
+		descriptor.LineCount,
+		descriptor.HLL,
+		descriptor.Bloom,
+		descriptor.Tag,
+		descriptor.Field,
+		txTimestamp,
+		0,
+		pb_timestamp.Timestamp{0, 0}, // lastMatchTimestamp is 0 when registering.
+		expiryTimestamp,
+		false,
+		nil,
+		nil,
+		hllArtifact,
+		bloomArtifact,
+		""}, nil
+}
+
+// queryRegisteredDataNames returns the dataNames of the descriptors which the owner already registered.
+func queryRegisteredDataNames(stub shim.ChaincodeStubInterface, ownerId string, descriptors []DatasetDescriptor) (map[string]bool, error) {
+	var dataNames []string
+	for _, descriptor := range descriptors {
+		if len(descriptor.DataName) > 0 {
+			dataNames = append(dataNames, descriptor.DataName)
+		}
+	}
+	existing := map[string]bool{}
+	if len(dataNames) == 0 {
+		return existing, nil
+	}
+	dataNamesJSONasBytes, err := json.Marshal(dataNames)
+	if err != nil {
+		return nil, err
+	}
+	queryString := fmt.Sprintf("{\"selector\":{\"operationType\":\"DataRegister\",\"owner\":\"%s\",\"dataName\":{\"$in\":%s}}}",
+		ownerId, dataNamesJSONasBytes)
+	resultsIterator, err := stub.GetQueryResult(queryString)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var record struct {
+			DataName string `json:"dataName"`
+		}
+		err = json.Unmarshal(queryResponse.Value, &record)
+		if err != nil {
+			return nil, err
+		}
+		existing[record.DataName] = true
+	}
+	return existing, nil
+}
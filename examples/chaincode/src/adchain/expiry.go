@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	pb_timestamp "github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const defaultSweepPageSize = 100
+
+const secondsPerDay = 24 * 60 * 60
+
+// notSweptSelector matches the data not marked by SweepExpired yet, including the records written before isExpired existed
+// which are only upgraded when read and have no isExpired field until Migrate rewrites them.
+const notSweptSelector = "\"$or\":[{\"isExpired\":false},{\"isExpired\":{\"$exists\":false}}]"
+
+// Sweep result schema is returned to the client by SweepExpired.
+type SweepResult struct {
+	Expired []string `json:"expired"` //keys of the data which have been marked as expired in this call
+	HasMore bool     `json:"hasMore"` //call SweepExpired again if true
+}
+
+// ========================================================
+// isDataExpired returns true if the data has been swept, or its expiry has passed at the txTimestamp.
+// Data registered without expiry never expires.
+// ========================================================
+func isDataExpired(record *DataRegistering, txTimestamp pb_timestamp.Timestamp) bool {
+	if record.IsExpired {
+		return true
+	}
+	if record.ExpiryTimestamp.Seconds == 0 && record.ExpiryTimestamp.Nanos == 0 {
+		return false
+	}
+	return !isBefore(txTimestamp, record.ExpiryTimestamp)
+}
+
+// ========================================================
+// checkDataNotExpired is the precondition check for the data taking part in OnBoarding and PanelRequest,
+// the queryResults are returned by queryByDataAndOperationType.
+// ========================================================
+func checkDataNotExpired(stub shim.ChaincodeStubInterface, queryResults []byte) error {
+	var queryResult_DataRegistering_Array QueryResult_DataRegistering_Array
+	err := json.Unmarshal(queryResults, &queryResult_DataRegistering_Array)
+	if err != nil {
+		return err
+	}
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	for _, queryResult := range queryResult_DataRegistering_Array {
+		if isDataExpired(&queryResult.Record, txTimestamp) {
+			expiry := time.Unix(queryResult.Record.ExpiryTimestamp.Seconds, 0).UTC().Format(time.RFC3339)
+			return errors.New(fmt.Sprintf("The data:%s of owner:%s expired at %s.", queryResult.Record.DataName, queryResult.Record.Owner, expiry))
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// SweepExpired marks the data whose expiry has passed as expired in bulk, so they can be found by a plain selector.
+// It is safe for anyone to call, because only the expiry written by the owner decides which data will be marked.
+// ============================================================================================================================
+func (t *AdChainChaincode) SweepExpired(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	//-------------1 optional parameter------------
+	//     0(optional)
+	//   "PageSize"
+
+	pageSize := defaultSweepPageSize
+	if len(args) > 0 && len(args[0]) > 0 {
+		var err error
+		pageSize, err = strconv.Atoi(args[0])
+		if err != nil || pageSize < 1 {
+			return shim.Error("1st argument must be a positive numeric string as pageSize of SweepExpired.")
+		}
+	}
+
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	queryString := fmt.Sprintf("{\"selector\":{\"operationType\":\"DataRegister\",%s,\"expiryTimestamp.seconds\":{\"$gt\":0,\"$lte\":%d}}}",
+		notSweptSelector, txTimestamp.Seconds)
+	queryResults, err := getQueryResultForQueryString(stub, queryString)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var queryResult_DataRegistering_Array QueryResult_DataRegistering_Array
+	err = json.Unmarshal(queryResults, &queryResult_DataRegistering_Array)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	result := SweepResult{[]string{}, false}
+	for _, queryResult := range queryResult_DataRegistering_Array {
+		if !isDataExpired(&queryResult.Record, txTimestamp) {
+			continue
+		}
+		if len(result.Expired) == pageSize {
+			result.HasMore = true
+			break
+		}
+		record := queryResult.Record
+		record.IsExpired = true
+		dataJSONasBytes, err := json.Marshal(record)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		dataJSONasBytes, err = stampWriter(stub, dataJSONasBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(queryResult.Key), redactRecord(dataJSONasBytes))
+		err = stub.PutState(queryResult.Key, dataJSONasBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		result.Expired = append(result.Expired, queryResult.Key)
+	}
+
+	newTxLogger(stub).Infof("SweepExpired marked %d data as expired", len(result.Expired))
+	resultJSONasBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultJSONasBytes)
+}
+
+// ============================================================================================================================
+// Query - query the data which are not expired yet but will expire within N days, optionally only the data of one owner.
+// ============================================================================================================================
+func (t *AdChainChaincode) QueryExpiringData(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	//-------------1 parameter is necessary------------
+	//     0          1(optional)
+	//   "Days"     "OwnerId"
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting at least 1 parameter for QueryExpiringData")
+	}
+	days, err := strconv.Atoi(args[0])
+	if err != nil || days < 0 {
+		return shim.Error("1st argument must be a non-negative numeric string as days of QueryExpiringData.")
+	}
+
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	until := txTimestamp.Seconds + int64(days)*secondsPerDay
+
+	var queryString string
+	if len(args) > 1 && len(args[1]) > 0 {
+		queryString = fmt.Sprintf("{\"selector\":{\"operationType\":\"DataRegister\",\"owner\":\"%s\",%s,\"expiryTimestamp.seconds\":{\"$gt\":%d,\"$lte\":%d}}}",
+			args[1], notSweptSelector, txTimestamp.Seconds, until)
+	} else {
+		queryString = fmt.Sprintf("{\"selector\":{\"operationType\":\"DataRegister\",%s,\"expiryTimestamp.seconds\":{\"$gt\":%d,\"$lte\":%d}}}",
+			notSweptSelector, txTimestamp.Seconds, until)
+	}
+	queryResults, err := getQueryResultForQueryString(stub, queryString)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(queryResults)
+}
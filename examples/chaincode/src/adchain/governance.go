@@ -0,0 +1,408 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	pb_timestamp "github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Proposal schema is used for a privileged change which has to be approved by several organizations.
+// To store this data the key will be: "Propose" + "_" + TxID
+type Proposal struct {
+	OperationType    string                 `json:"operationType"`       //operationType is used to distinguish the various types of operations(Propose)
+	SchemaVersion    int                    `json:"schemaVersion"`       //schemaVersion is the version of this schema when the record was written, see currentSchemaVersion
+	ProposalId       string                 `json:"proposalId"`          //txID of the Propose transaction
+	Proposer         string                 `json:"proposer"`            //ownerId of the proposer
+	Action           string                 `json:"action"`              //one of the governanceActions, like OrgSuspend
+	Params           []string               `json:"params"`              //parameters of the action
+	Deadline         pb_timestamp.Timestamp `json:"deadline"`            //votes are not accepted after deadline
+	Status           string                 `json:"status"`              //one of: open; applied; rejected
+	Votes            []ProposalVote         `json:"votes"`               //the proposer votes for the proposal when proposing
+	Timestamp        pb_timestamp.Timestamp `json:"timestamp"`           //the time when the action happens
+	AppliedTimestamp pb_timestamp.Timestamp `json:"appliedTimestamp"`    //the time when the proposal was applied or rejected
+	UpdatedBy        string                 `json:"updatedBy,omitempty"` //ownerId of the identity which wrote this value, see GetRecordHistory
+}
+
+type ProposalVote struct {
+	Voter     string                 `json:"voter"`   //ownerId of the voter
+	OrgName   string                 `json:"orgName"` //organization name of the OrgRegister record, for display only
+	MspId     string                 `json:"mspId"`   //each MSP can only vote once, whichever cert is used
+	Approve   bool                   `json:"approve"`
+	Timestamp pb_timestamp.Timestamp `json:"timestamp"`
+}
+
+// Proposal tally is returned by GetProposal, it is not stored on chain.
+type ProposalTally struct {
+	Proposal   Proposal `json:"proposal"`
+	Status     string   `json:"status"` //same as proposal status, except an open proposal after deadline is expired
+	Approvals  int      `json:"approvals"`
+	Rejections int      `json:"rejections"`
+	Quorum     int      `json:"quorum"`
+}
+
+// Governance config schema holds how many distinct MSPs have to approve a proposal.
+// To store this data the key will be: "GovernanceConfig"
+type GovernanceConfig struct {
+	Quorum int `json:"quorum"`
+}
+
+const governanceConfigKey = "GovernanceConfig"
+
+const defaultQuorum = 2
+
+const (
+	proposalStatusOpen     = "open"
+	proposalStatusApplied  = "applied"
+	proposalStatusRejected = "rejected"
+	proposalStatusExpired  = "expired"
+)
+
+// governanceAction is a privileged change, validate is called on Propose and apply once the quorum is reached.
+type governanceAction struct {
+	validate func(stub shim.ChaincodeStubInterface, params []string) error
+	apply    func(stub shim.ChaincodeStubInterface, params []string, updatedBy string) error
+}
+
+var governanceActions = map[string]governanceAction{
+	"OrgSuspend":    {validateOrgStatusParams, applyOrgSuspend},
+	"OrgReinstate":  {validateOrgStatusParams, applyOrgReinstate},
+	"OrgDeregister": {validateOrgStatusParams, applyOrgDeregister},
+	"SetQuorum":     {validateQuorumParams, applySetQuorum},
+	"SetQuota":      {validateQuotaParams, applySetQuota},
+	"SetRoleOrgs":   {validateRoleOrgsParams, applySetRoleOrgs},
+}
+
+// ========================================================
+// initGovernanceConfig is called by Init with the optional "Quorum" argument. Without it the stored quorum is kept,
+// so an upgrade does not undo a SetQuorum proposal.
+// ========================================================
+func initGovernanceConfig(stub shim.ChaincodeStubInterface, quorum string) error {
+	config, err := getGovernanceConfig(stub)
+	if err != nil {
+		return err
+	}
+	if len(quorum) > 0 {
+		config.Quorum, err = strconv.Atoi(quorum)
+		if err != nil || config.Quorum < 1 {
+			return errors.New("Quorum argument must be a positive numeric string as quorum of GovernanceConfig.")
+		}
+	}
+	return putGovernanceConfig(stub, config)
+}
+
+func getGovernanceConfig(stub shim.ChaincodeStubInterface) (GovernanceConfig, error) {
+	config := GovernanceConfig{defaultQuorum}
+	configJSONasBytes, err := stub.GetState(governanceConfigKey)
+	if err != nil {
+		return config, err
+	}
+	if configJSONasBytes != nil {
+		err = json.Unmarshal(configJSONasBytes, &config)
+	}
+	return config, err
+}
+
+func putGovernanceConfig(stub shim.ChaincodeStubInterface, config GovernanceConfig) error {
+	configJSONasBytes, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(governanceConfigKey, configJSONasBytes)
+}
+
+// SetQuorum takes the parameter: "Quorum"
+func validateQuorumParams(stub shim.ChaincodeStubInterface, params []string) error {
+	if len(params) != 1 {
+		return errors.New("Incorrect params. Expecting Quorum.")
+	}
+	quorum, err := strconv.Atoi(params[0])
+	if err != nil || quorum < 1 {
+		return errors.New("Incorrect params. Expecting a positive numeric string as Quorum.")
+	}
+	return nil
+}
+
+func applySetQuorum(stub shim.ChaincodeStubInterface, params []string, updatedBy string) error {
+	quorum, _ := strconv.Atoi(params[0])
+	newTxLogger(stub).Infof("The quorum is %d, updated by %s", quorum, updatedBy)
+	return putGovernanceConfig(stub, GovernanceConfig{quorum})
+}
+
+// ============================================================================================================================
+// Propose is used by a registered organization to propose a privileged change, the proposer approves it at the same time.
+// The change is applied automatically once a quorum of distinct MSPs approved it before the deadline.
+// ============================================================================================================================
+func (t *AdChainChaincode) Propose(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	//-------------at least 2 parameters------------
+	//     0           1                              2...
+	//  "Action"   "Deadline"(72h or RFC3339)     "Params"...
+
+	// ==== Input sanitation ====
+	if len(args) < 2 {
+		return shim.Error("Incorrect number of arguments. Expecting at least 2 parameters for Propose")
+	}
+	for i := 0; i < 2; i++ {
+		if len(args[i]) <= 0 {
+			return shim.Error(strconv.Itoa(i) + "th argument must be a non-empty string")
+		}
+	}
+
+	return submitProposal(stub, function, args[0], args[1], args[2:])
+}
+
+// ============================================================================================================================
+// submitProposal validates the action and stores the proposal with the vote of the proposer.
+// ============================================================================================================================
+func submitProposal(stub shim.ChaincodeStubInterface, operationType string, actionName string, deadlineArg string, params []string) pb.Response {
+	txID := stub.GetTxID()
+
+	action, ok := governanceActions[actionName]
+	if !ok {
+		return shim.Error(fmt.Sprintf("Current action:%s has not been supported yet.", actionName))
+	}
+	err := action.validate(stub, params)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	deadline, err := parseDeadline(txTimestamp, deadlineArg)
+	if err != nil {
+		return shim.Error("2nd argument must be a duration(like 72h) or a RFC3339 time as deadline of Propose.")
+	}
+	if !isBefore(txTimestamp, deadline) {
+		return shim.Error("2nd argument must be a deadline in the future.")
+	}
+
+	proposal := &Proposal{operationType,
+		currentSchemaVersion,
+		txID,
+		"",
+		actionName,
+		params,
+		deadline,
+		proposalStatusOpen,
+		nil,
+		txTimestamp,
+		pb_timestamp.Timestamp{0, 0}, // appliedTimestamp is 0 when proposing.
+		""}
+
+	return voteOnProposal(stub, operationType+"_"+txID, proposal, true)
+}
+
+// ============================================================================================================================
+// Vote is used by a registered organization to approve or reject an open proposal.
+// ============================================================================================================================
+func (t *AdChainChaincode) Vote(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	//-------------2 parameters------------
+	//      0              1
+	//  "ProposalId"   "Approve"
+
+	// ==== Input sanitation ====
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 parameters for Vote")
+	}
+	approve, err := strconv.ParseBool(args[1])
+	if err != nil {
+		return shim.Error("2nd argument must be a boolean as approve of Vote.")
+	}
+
+	key := "Propose" + "_" + args[0]
+	proposal, err := getProposal(stub, key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if proposal.Status != proposalStatusOpen {
+		return shim.Error(fmt.Sprintf("The proposal:%s is %s, can not vote any more.", args[0], proposal.Status))
+	}
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !isBefore(txTimestamp, proposal.Deadline) {
+		return shim.Error(fmt.Sprintf("The proposal:%s is expired, can not vote any more.", args[0]))
+	}
+
+	return voteOnProposal(stub, key, proposal, approve)
+}
+
+// ============================================================================================================================
+// voteOnProposal adds the vote of current owner, then applies or rejects the proposal once a quorum is reached.
+// ============================================================================================================================
+func voteOnProposal(stub shim.ChaincodeStubInterface, key string, proposal *Proposal, approve bool) pb.Response {
+	ownerId, err := generateOwnerIdByCert(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	org, _, err := getOrgRegistering(stub, ownerId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if org == nil {
+		return shim.Error(fmt.Sprintf("Current owner:%s has not registered yet, please do OrgRegister first.", ownerId))
+	}
+	err = checkOrgStatus(org, "Current owner")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// the MSP id comes from the creator which the peer has validated, the org name of the cert is chosen by its CA
+	mspId, err := getMspId(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, vote := range proposal.Votes {
+		if vote.MspId == mspId {
+			return shim.Error(fmt.Sprintf("MSP:%s already voted for the proposal:%s.", mspId, proposal.ProposalId))
+		}
+	}
+
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(proposal.Proposer) == 0 {
+		proposal.Proposer = ownerId
+	}
+	proposal.Votes = append(proposal.Votes, ProposalVote{ownerId, org.OrgName, mspId, approve, txTimestamp})
+
+	config, err := getGovernanceConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	approvals, rejections := countVotes(proposal)
+	if approvals >= config.Quorum {
+		err = governanceActions[proposal.Action].apply(stub, proposal.Params, "Propose_"+proposal.ProposalId)
+		if err != nil {
+			//the whole transaction fails, so nothing apply wrote is committed. The proposal stays open and the vote can be
+			//cast again once the action can be applied, otherwise the proposal expires.
+			newTxLogger(stub).Warningf("Failed to apply the proposal:%s, err:%s", proposal.ProposalId, err)
+			return shim.Error(fmt.Sprintf("Failed to apply the proposal:%s, err:%s", proposal.ProposalId, err))
+		}
+		proposal.Status = proposalStatusApplied
+		proposal.AppliedTimestamp = txTimestamp
+	} else if rejections >= config.Quorum {
+		proposal.Status = proposalStatusRejected
+		proposal.AppliedTimestamp = txTimestamp
+	}
+
+	dataJSONasBytes, err := json.Marshal(proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	dataJSONasBytes, err = stampWriter(stub, dataJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(key), redactRecord(dataJSONasBytes))
+	err = stub.PutState(key, dataJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(proposal.ProposalId))
+}
+
+// countVotes returns how many distinct MSPs approved and rejected. The votes cast before the MSP id was recorded
+// are not counted.
+func countVotes(proposal *Proposal) (int, int) {
+	approvals := map[string]bool{}
+	rejections := map[string]bool{}
+	for _, vote := range proposal.Votes {
+		if len(vote.MspId) == 0 {
+			continue
+		}
+		if vote.Approve {
+			approvals[vote.MspId] = true
+		} else {
+			rejections[vote.MspId] = true
+		}
+	}
+	return len(approvals), len(rejections)
+}
+
+func getProposal(stub shim.ChaincodeStubInterface, key string) (*Proposal, error) {
+	proposalJSONasBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if proposalJSONasBytes == nil {
+		return nil, errors.New(fmt.Sprintf("The proposal:%s doesn't exist.", strings.TrimPrefix(key, "Propose_")))
+	}
+	proposalJSONasBytes, _, err = upgradeRecord(proposalJSONasBytes)
+	if err != nil {
+		return nil, err
+	}
+	var proposal Proposal
+	err = json.Unmarshal(proposalJSONasBytes, &proposal)
+	if err != nil {
+		return nil, err
+	}
+	return &proposal, nil
+}
+
+// ============================================================================================================================
+// GetProposal - query a proposal together with its tally.
+// ============================================================================================================================
+func (t *AdChainChaincode) GetProposal(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+
+	if len(args) != 1 || len(args[0]) == 0 {
+		return shim.Error("Incorrect number of arguments. Expecting ProposalId to query")
+	}
+
+	proposal, err := getProposal(stub, "Propose"+"_"+args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	config, err := getGovernanceConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	approvals, rejections := countVotes(proposal)
+	status := proposal.Status
+	if status == proposalStatusOpen && !isBefore(txTimestamp, proposal.Deadline) {
+		status = proposalStatusExpired
+	}
+	tallyJSONasBytes, err := json.Marshal(&ProposalTally{*proposal, status, approvals, rejections, config.Quorum})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(tallyJSONasBytes)
+}
+
+// ============================================================================================================================
+// ListProposals - query the proposals, filtered by the optional status(open; applied; rejected).
+// ============================================================================================================================
+func (t *AdChainChaincode) ListProposals(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+
+	queryString := "{\"selector\":{\"operationType\":\"Propose\"}}"
+	if len(args) > 0 && len(args[0]) > 0 {
+		//only the stored statuses are accepted, so the argument can not change the query. An expired proposal is still open on chain.
+		status := strings.ToLower(args[0])
+		if status != proposalStatusOpen && status != proposalStatusApplied && status != proposalStatusRejected {
+			return shim.Error(fmt.Sprintf("Incorrect status:%s. Expecting one of: %s, %s, %s.", args[0], proposalStatusOpen, proposalStatusApplied, proposalStatusRejected))
+		}
+		queryString = fmt.Sprintf("{\"selector\":{\"operationType\":\"Propose\",\"status\":\"%s\"}}", status)
+	}
+	queryResults, err := getQueryResultForQueryString(stub, queryString)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(queryResults)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	pb_timestamp "github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// History entry schema is returned by GetRecordHistory, it is not stored on chain.
+type HistoryEntry struct {
+	TxID      string                 `json:"txID"`      //the transaction which wrote this value
+	Timestamp pb_timestamp.Timestamp `json:"timestamp"` //the time of the transaction
+	UpdatedBy string                 `json:"updatedBy"` //ownerId of the identity which wrote this value, empty for values written before it was recorded
+	IsDelete  bool                   `json:"isDelete"`
+	Value     json.RawMessage        `json:"value,omitempty"` //the record as stored, omitted when deleted
+}
+
+// ========================================================
+// stampWriter records the ownerId of the submitter into the record json before PutState,
+// because the key history of the ledger only keeps the txID and timestamp.
+// ========================================================
+func stampWriter(stub shim.ChaincodeStubInterface, dataJSONasBytes []byte) ([]byte, error) {
+	ownerId, err := generateOwnerIdByCert(stub)
+	if err != nil {
+		return nil, err
+	}
+	var record map[string]interface{}
+	err = json.Unmarshal(dataJSONasBytes, &record)
+	if err != nil {
+		return nil, err
+	}
+	record["updatedBy"] = ownerId
+	return json.Marshal(record)
+}
+
+// ============================================================================================================================
+// GetRecordHistory - query every value a record ever had, with the txID, timestamp and the identity which wrote it.
+// Only the parties of the record(owner, targetOwner, sponsor, providers) and auditors can read the history.
+// ============================================================================================================================
+func (t *AdChainChaincode) GetRecordHistory(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	//-------------1 parameter------------
+	//    0
+	//  "Key"
+
+	if len(args) != 1 || len(args[0]) == 0 {
+		return shim.Error("Incorrect number of arguments. Expecting key of the record to query history")
+	}
+	key := args[0]
+
+	resultsIterator, err := stub.GetHistoryForKey(key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var history []HistoryEntry
+	var parties []string
+	isPublic := false
+	for resultsIterator.HasNext() {
+		modification, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		entry := HistoryEntry{TxID: modification.TxId, IsDelete: modification.IsDelete}
+		if modification.Timestamp != nil {
+			entry.Timestamp = pb_timestamp.Timestamp{Seconds: modification.Timestamp.Seconds, Nanos: modification.Timestamp.Nanos}
+		}
+		if !modification.IsDelete {
+			var record map[string]interface{}
+			err = json.Unmarshal(modification.Value, &record)
+			if err != nil {
+				return shim.Error(fmt.Sprintf("The key:%s is not a record of adchain.", key))
+			}
+			entry.UpdatedBy, _ = record["updatedBy"].(string)
+			entry.Value = modification.Value
+			parties = append(parties, recordParties(record)...)
+			isPublic = isPublic || isPublicRecord(record)
+		}
+		history = append(history, entry)
+	}
+	if len(history) == 0 {
+		return shim.Error(fmt.Sprintf("The key:%s doesn't have any history.", key))
+	}
+
+	err = checkHistoryAccess(stub, parties, isPublic)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	historyJSONasBytes, err := json.Marshal(history)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(historyJSONasBytes)
+}
+
+// ========================================================
+// recordParties collects the ownerIds which take part in the record.
+// ========================================================
+func recordParties(record map[string]interface{}) []string {
+	var parties []string
+	for _, field := range []string{"owner", "targetOwner", "sponsor"} {
+		if party, ok := record[field].(string); ok && len(party) > 0 {
+			parties = append(parties, party)
+		}
+	}
+	if providers, ok := record["providers"].(map[string]interface{}); ok {
+		if genderProviderArray, ok := providers["genderProviderArray"].([]interface{}); ok {
+			for _, provider := range genderProviderArray {
+				if provider, ok := provider.(map[string]interface{}); ok {
+					if providerId, ok := provider["providerId"].(string); ok {
+						parties = append(parties, providerId)
+					}
+				}
+			}
+		}
+	}
+	return parties
+}
+
+// isPublicRecord returns true for the records every organization can read, like governance proposals.
+func isPublicRecord(record map[string]interface{}) bool {
+	operationType, _ := record["operationType"].(string)
+	return operationType == "Propose"
+}
+
+func checkHistoryAccess(stub shim.ChaincodeStubInterface, parties []string, isPublic bool) error {
+	if isPublic {
+		return nil
+	}
+	roles, err := getRoles(stub)
+	if err != nil {
+		return err
+	}
+	if containsString(roles, roleAuditor) {
+		return nil
+	}
+	ownerId, err := generateOwnerIdByCert(stub)
+	if err != nil {
+		return err
+	}
+	if containsString(parties, ownerId) {
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Current owner:%s is not a party of the record, can not read its history.", ownerId))
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// defaultOrgStatusDeadline is how long the other organizations have to vote on OrgSuspend, OrgReinstate and OrgDeregister.
+const defaultOrgStatusDeadline = "72h"
+
+// Status of a registered organization, only active organizations can pass the precondition checks.
+const (
+	orgStatusActive       = "active"
+	orgStatusSuspended    = "suspended"
+	orgStatusDeregistered = "deregistered"
+)
+
+// ============================================================================================================================
+// getOrgRegistering returns the OrgRegister record and its key, the record is nil if the ownerId has not registered yet.
+// If an org registry chaincode is configured(see registry.go), the existence and identity come from it, and the local record
+// only adds what adchain keeps by itself(status and public key). The local record is the fallback for the owners the registry
+// does not know. The key is always the local one, so the status changes are written locally.
+// ============================================================================================================================
+func getOrgRegistering(stub shim.ChaincodeStubInterface, ownerId string) (*OrgRegistering, string, error) {
+	local, key, err := getLocalOrgRegistering(stub, ownerId)
+	if err != nil {
+		return nil, "", err
+	}
+	config, err := getRegistryConfig(stub)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(config.ChaincodeName) == 0 {
+		return local, key, nil
+	}
+
+	org, err := queryOrgRegistry(stub, config, ownerId)
+	if err != nil {
+		return nil, "", err
+	}
+	if org == nil {
+		return local, key, nil
+	}
+	if local != nil {
+		org.Status = local.Status
+		org.StatusReason = local.StatusReason
+		org.StatusUpdatedBy = local.StatusUpdatedBy
+		org.StatusTimestamp = local.StatusTimestamp
+		if len(local.PublicKey) > 0 {
+			org.PublicKey = local.PublicKey
+		}
+		return org, key, nil
+	}
+	return org, "OrgRegister" + "_" + ownerId, nil
+}
+
+// getLocalOrgRegistering only looks at the OrgRegister records kept by adchain.
+func getLocalOrgRegistering(stub shim.ChaincodeStubInterface, ownerId string) (*OrgRegistering, string, error) {
+	queryResults, err := queryByOwnerAndOperationType(stub, "OrgRegister", ownerId)
+	if err != nil {
+		return nil, "", err
+	}
+	var queryResultArray []struct {
+		Key    string         `json:"Key"`
+		Record OrgRegistering `json:"Record"`
+	}
+	err = json.Unmarshal(queryResults, &queryResultArray)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(queryResultArray) == 0 {
+		return nil, "", nil
+	}
+	if len(queryResultArray) > 1 {
+		return nil, "", errors.New(fmt.Sprintf("The owner:%s has duplicated OrgRegister records.", ownerId))
+	}
+	return &queryResultArray[0].Record, queryResultArray[0].Key, nil
+}
+
+// ============================================================================================================================
+// checkOrgActive is the precondition check for every party of a transaction, the party must have registered and must not be
+// suspended or deregistered. The party is used in error message, like "Current owner", "targetOwner", "ProviderId".
+// ============================================================================================================================
+func checkOrgActive(stub shim.ChaincodeStubInterface, ownerId string, party string) error {
+	org, _, err := getOrgRegistering(stub, ownerId)
+	if err != nil {
+		return err
+	}
+	if org == nil {
+		newTxLogger(stub).Warningf("%s:%s has not registered yet, please do OrgRegister first.", party, redactOwner(ownerId))
+		return errors.New(fmt.Sprintf("%s:%s has not registered yet, please do OrgRegister first.", party, ownerId))
+	}
+	return checkOrgStatus(org, party)
+}
+
+// checkOrgStatus returns error if the registered org is suspended or deregistered.
+func checkOrgStatus(org *OrgRegistering, party string) error {
+	if org.Status != orgStatusActive {
+		return errors.New(fmt.Sprintf("%s:%s is %s, reason:%s", party, org.Owner, org.Status, org.StatusReason))
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// The status changes are applied by governance proposals(see governance.go), so they never rest on a single cert.
+//
+//	OrgSuspend    stops a misbehaving organization, the in-flight OnBoarding and PanelRequest are flagged.
+//	OrgReinstate  makes a suspended organization active again.
+//	OrgDeregister removes an organization permanently, the record is kept with the reason for audit.
+//
+// Each of them takes the parameters: "OwnerId" "Reason"
+// ============================================================================================================================
+func (t *AdChainChaincode) OrgSuspend(stub shim.ChaincodeStubInterface) pb.Response {
+	return t.proposeOrgStatus(stub)
+}
+
+func (t *AdChainChaincode) OrgReinstate(stub shim.ChaincodeStubInterface) pb.Response {
+	return t.proposeOrgStatus(stub)
+}
+
+func (t *AdChainChaincode) OrgDeregister(stub shim.ChaincodeStubInterface) pb.Response {
+	return t.proposeOrgStatus(stub)
+}
+
+// proposeOrgStatus keeps the admin invokes, they submit a proposal of the same name which the admin approves at once.
+// The status changes when the quorum is reached, the proposalId is returned.
+func (t *AdChainChaincode) proposeOrgStatus(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	//-------------3 parameters------------
+	//     0          1           2(optional)
+	//  "OwnerId"  "Reason"    "Deadline"(72h or RFC3339, defaultOrgStatusDeadline by default)
+
+	// ==== Input sanitation ====
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 to 3 parameters for " + function)
+	}
+	for i := 0; i < 2; i++ {
+		if len(args[i]) <= 0 {
+			return shim.Error(strconv.Itoa(i) + "th argument must be a non-empty string")
+		}
+	}
+	deadline := defaultOrgStatusDeadline
+	if len(args) == 3 && len(args[2]) > 0 {
+		deadline = args[2]
+	}
+	return submitProposal(stub, "Propose", function, deadline, args[:2])
+}
+
+func applyOrgSuspend(stub shim.ChaincodeStubInterface, params []string, updatedBy string) error {
+	return setOrgStatus(stub, params[0], []string{orgStatusActive}, orgStatusSuspended, params[1], updatedBy)
+}
+
+func applyOrgReinstate(stub shim.ChaincodeStubInterface, params []string, updatedBy string) error {
+	return setOrgStatus(stub, params[0], []string{orgStatusSuspended}, orgStatusActive, params[1], updatedBy)
+}
+
+func applyOrgDeregister(stub shim.ChaincodeStubInterface, params []string, updatedBy string) error {
+	return setOrgStatus(stub, params[0], []string{orgStatusActive, orgStatusSuspended}, orgStatusDeregistered, params[1], updatedBy)
+}
+
+// validateOrgStatusParams is called when the proposal is submitted, so a wrong ownerId is rejected before voting.
+func validateOrgStatusParams(stub shim.ChaincodeStubInterface, params []string) error {
+	if len(params) != 2 || len(params[0]) == 0 || len(params[1]) == 0 {
+		return errors.New("Incorrect params. Expecting non-empty OwnerId and Reason.")
+	}
+	org, _, err := getOrgRegistering(stub, strings.ToLower(params[0]))
+	if err != nil {
+		return err
+	}
+	if org == nil {
+		return errors.New(fmt.Sprintf("The owner:%s has not registered yet.", params[0]))
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// setOrgStatus moves the org from one of fromStatus to toStatus, and flags the in-flight sessions unless the org becomes active.
+// ============================================================================================================================
+func setOrgStatus(stub shim.ChaincodeStubInterface, ownerId string, fromStatus []string, toStatus string, reason string, updatedBy string) error {
+	ownerId = strings.ToLower(ownerId)
+	org, key, err := getOrgRegistering(stub, ownerId)
+	if err != nil {
+		return err
+	}
+	if org == nil {
+		return errors.New(fmt.Sprintf("The owner:%s has not registered yet.", ownerId))
+	}
+	if !containsString(fromStatus, org.Status) {
+		return errors.New(fmt.Sprintf("The owner:%s is %s, can not be %s.", ownerId, org.Status, toStatus))
+	}
+
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	org.Status = toStatus
+	org.StatusReason = reason
+	org.StatusUpdatedBy = updatedBy
+	org.StatusTimestamp = txTimestamp
+
+	dataJSONasBytes, err := json.Marshal(org)
+	if err != nil {
+		return err
+	}
+	dataJSONasBytes, err = stampWriter(stub, dataJSONasBytes)
+	if err != nil {
+		return err
+	}
+	newTxLogger(stub).Infof("The owner:%s is %s", redactOwner(ownerId), toStatus)
+	newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(key), redactRecord(dataJSONasBytes))
+	err = stub.PutState(key, dataJSONasBytes)
+	if err != nil {
+		return err
+	}
+
+	if toStatus == orgStatusActive {
+		return nil
+	}
+	return flagInFlightSessions(stub, ownerId, fmt.Sprintf("%s %s: %s", ownerId, toStatus, reason))
+}
+
+// ============================================================================================================================
+// flagInFlightSessions marks the unfinished OnBoarding and PanelRequest records in which the owner takes part.
+// ============================================================================================================================
+func flagInFlightSessions(stub shim.ChaincodeStubInterface, ownerId string, flag string) error {
+	queryString := fmt.Sprintf("{\"selector\":{\"operationType\":\"OnBoarding\",\"isFinished\":false,\"$or\":[{\"owner\":\"%s\"},{\"targetOwner\":\"%s\"}]}}",
+		ownerId, ownerId)
+	queryResults, err := getQueryResultForQueryString(stub, queryString)
+	if err != nil {
+		return err
+	}
+	var onBoardingArray []struct {
+		Key    string     `json:"Key"`
+		Record OnBoarding `json:"Record"`
+	}
+	err = json.Unmarshal(queryResults, &onBoardingArray)
+	if err != nil {
+		return err
+	}
+	for _, result := range onBoardingArray {
+		result.Record.Flags = append(result.Record.Flags, flag)
+		err = putFlaggedRecord(stub, result.Key, result.Record)
+		if err != nil {
+			return err
+		}
+	}
+
+	queryString = fmt.Sprintf("{\"selector\":{\"operationType\":\"PanelRequest\",\"isFinished\":false,\"$or\":[{\"sponsor\":\"%s\"},{\"providers.genderProviderArray\":{\"$elemMatch\":{\"providerId\":\"%s\"}}}]}}",
+		ownerId, ownerId)
+	queryResults, err = getQueryResultForQueryString(stub, queryString)
+	if err != nil {
+		return err
+	}
+	var panelingArray []struct {
+		Key    string   `json:"Key"`
+		Record Paneling `json:"Record"`
+	}
+	err = json.Unmarshal(queryResults, &panelingArray)
+	if err != nil {
+		return err
+	}
+	for _, result := range panelingArray {
+		result.Record.Flags = append(result.Record.Flags, flag)
+		err = putFlaggedRecord(stub, result.Key, result.Record)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func putFlaggedRecord(stub shim.ChaincodeStubInterface, key string, record interface{}) error {
+	dataJSONasBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	dataJSONasBytes, err = stampWriter(stub, dataJSONasBytes)
+	if err != nil {
+		return err
+	}
+	newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(key), redactRecord(dataJSONasBytes))
+	return stub.PutState(key, dataJSONasBytes)
+}
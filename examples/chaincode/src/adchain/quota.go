@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	pb_timestamp "github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Quota rule schema limits how often an owner may call a function.
+// Limit calls are allowed in any sliding window of WindowSeconds, and at most MaxOpen sessions may be unfinished at the same time.
+// 0 means unlimited for both Limit and MaxOpen.
+type QuotaRule struct {
+	Limit         int   `json:"limit"`
+	WindowSeconds int64 `json:"windowSeconds"`
+	MaxOpen       int   `json:"maxOpen"`
+}
+
+// Quota config schema holds the default rules per function, and the rules which override them for single owners.
+// It is changed by the governance action SetQuota.
+// To store this data the key will be: "QuotaConfig"
+type QuotaConfig struct {
+	Default map[string]QuotaRule            `json:"default"` //function => rule
+	Owners  map[string]map[string]QuotaRule `json:"owners"`  //ownerId => function => rule
+}
+
+// Quota usage schema keeps the timestamps of the calls within the latest window.
+// To store this data the key will be: "Quota_" + ownerId + "_" + function
+type QuotaUsage struct {
+	OperationType string  `json:"operationType"` //always Quota
+	Owner         string  `json:"owner"`
+	Function      string  `json:"function"`
+	Calls         []int64 `json:"calls"` //tx timestamps in seconds, the ones out of the window are dropped on each call
+}
+
+// Quota status schema is returned to the client by GetQuota, it is not stored on chain.
+type QuotaStatus struct {
+	Function  string                 `json:"function"`
+	Rule      QuotaRule              `json:"rule"`
+	Used      int                    `json:"used"`      //calls within the current window
+	Remaining int                    `json:"remaining"` //-1 means unlimited
+	ResetAt   pb_timestamp.Timestamp `json:"resetAt"`   //when the oldest call leaves the window, 0 if nothing is used
+	Open      int                    `json:"open"`      //unfinished sessions, only counted if MaxOpen is set
+}
+
+const quotaConfigKey = "QuotaConfig"
+
+// quotaFunctions are the functions which consume quota, OnBoarding only counts the new sessions(step 1).
+var quotaFunctions = []string{"OnBoarding", "PanelRequest", "DataRegister"}
+
+// defaultQuotaConfig is used until the first SetQuota is applied, a new one is returned each time because the maps are changed in place.
+func defaultQuotaConfig() QuotaConfig {
+	return QuotaConfig{
+		map[string]QuotaRule{
+			"OnBoarding":   {100, secondsPerDay, 0},
+			"PanelRequest": {20, secondsPerDay, 10},
+		},
+		map[string]map[string]QuotaRule{},
+	}
+}
+
+func getQuotaConfig(stub shim.ChaincodeStubInterface) (QuotaConfig, error) {
+	configJSONasBytes, err := stub.GetState(quotaConfigKey)
+	if err != nil {
+		return QuotaConfig{}, err
+	}
+	if configJSONasBytes == nil {
+		return defaultQuotaConfig(), nil
+	}
+	var config QuotaConfig
+	err = json.Unmarshal(configJSONasBytes, &config)
+	if config.Default == nil {
+		config.Default = map[string]QuotaRule{}
+	}
+	if config.Owners == nil {
+		config.Owners = map[string]map[string]QuotaRule{}
+	}
+	return config, err
+}
+
+func putQuotaConfig(stub shim.ChaincodeStubInterface, config QuotaConfig) error {
+	configJSONasBytes, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(quotaConfigKey, configJSONasBytes)
+}
+
+// quotaRule returns the rule of the owner for the function, the owner's own rule wins over the default one.
+func (config QuotaConfig) quotaRule(ownerId string, function string) QuotaRule {
+	if rule, ok := config.Owners[ownerId][function]; ok {
+		return rule
+	}
+	return config.Default[function]
+}
+
+// SetQuota takes the parameters: "OwnerId"("*" for the default rule) "Function" "Limit" "Window"(like 24h) "MaxOpen"
+func validateQuotaParams(stub shim.ChaincodeStubInterface, params []string) error {
+	if len(params) != 5 {
+		return errors.New("Incorrect params. Expecting OwnerId, Function, Limit, Window and MaxOpen.")
+	}
+	if !containsString(quotaFunctions, params[1]) {
+		return errors.New(fmt.Sprintf("Incorrect params. Function must be one of: %s.", strings.Join(quotaFunctions, ", ")))
+	}
+	_, err := parseQuotaRule(params[2:])
+	if err != nil {
+		return err
+	}
+	if params[0] == "*" {
+		return nil
+	}
+	org, _, err := getOrgRegistering(stub, strings.ToLower(params[0]))
+	if err != nil {
+		return err
+	}
+	if org == nil {
+		return errors.New(fmt.Sprintf("The owner:%s has not registered yet.", params[0]))
+	}
+	return nil
+}
+
+func applySetQuota(stub shim.ChaincodeStubInterface, params []string, updatedBy string) error {
+	rule, err := parseQuotaRule(params[2:])
+	if err != nil {
+		return err
+	}
+	config, err := getQuotaConfig(stub)
+	if err != nil {
+		return err
+	}
+	ownerId := strings.ToLower(params[0])
+	if ownerId == "*" {
+		config.Default[params[1]] = rule
+	} else {
+		if config.Owners[ownerId] == nil {
+			config.Owners[ownerId] = map[string]QuotaRule{}
+		}
+		config.Owners[ownerId][params[1]] = rule
+	}
+	newTxLogger(stub).Infof("The quota of %s for %s is %+v, updated by %s", redactOwner(ownerId), params[1], rule, updatedBy)
+	return putQuotaConfig(stub, config)
+}
+
+// parseQuotaRule parses "Limit" "Window" "MaxOpen".
+func parseQuotaRule(params []string) (QuotaRule, error) {
+	limit, err := strconv.Atoi(params[0])
+	if err != nil || limit < 0 {
+		return QuotaRule{}, errors.New("Incorrect params. Expecting a non-negative numeric string as Limit.")
+	}
+	window, err := time.ParseDuration(params[1])
+	if err != nil || window < time.Second {
+		return QuotaRule{}, errors.New("Incorrect params. Expecting a duration of at least 1s as Window, like 24h.")
+	}
+	maxOpen, err := strconv.Atoi(params[2])
+	if err != nil || maxOpen < 0 {
+		return QuotaRule{}, errors.New("Incorrect params. Expecting a non-negative numeric string as MaxOpen.")
+	}
+	return QuotaRule{limit, int64(window / time.Second), maxOpen}, nil
+}
+
+// ============================================================================================================================
+// consumeQuota is the precondition check of the functions in quotaFunctions, it records the call if the owner is within quota.
+// ============================================================================================================================
+func consumeQuota(stub shim.ChaincodeStubInterface, ownerId string, function string) error {
+	return consumeQuotaCount(stub, ownerId, function, 1)
+}
+
+// consumeQuotaCount records count calls at once, because the usage written in a transaction can not be read back by it.
+func consumeQuotaCount(stub shim.ChaincodeStubInterface, ownerId string, function string, count int) error {
+	config, err := getQuotaConfig(stub)
+	if err != nil {
+		return err
+	}
+	rule := config.quotaRule(ownerId, function)
+	if rule.Limit == 0 && rule.MaxOpen == 0 {
+		return nil
+	}
+
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return err
+	}
+
+	if rule.MaxOpen > 0 {
+		open, err := countOpenSessions(stub, ownerId, function)
+		if err != nil {
+			return err
+		}
+		if open >= rule.MaxOpen {
+			return errors.New(fmt.Sprintf("Quota exceeded: owner:%s already has %d unfinished %s, the max is %d.", ownerId, open, function, rule.MaxOpen))
+		}
+	}
+
+	if rule.Limit == 0 {
+		return nil
+	}
+	key, usage, err := getQuotaUsage(stub, ownerId, function)
+	if err != nil {
+		return err
+	}
+	usage.Calls = callsInWindow(usage.Calls, rule, txTimestamp)
+	if count > rule.Limit {
+		return errors.New(fmt.Sprintf("Quota exceeded: owner:%s can call %s only %d times within %s, %d calls are requested at once.",
+			ownerId, function, rule.Limit, time.Duration(rule.WindowSeconds)*time.Second, count))
+	}
+	if len(usage.Calls)+count > rule.Limit {
+		resetAt := time.Unix(windowResetAt(usage.Calls, rule), 0).UTC().Format(time.RFC3339)
+		return errors.New(fmt.Sprintf("Quota exceeded: owner:%s already called %s %d times within %s, the next call is allowed at %s.",
+			ownerId, function, len(usage.Calls), time.Duration(rule.WindowSeconds)*time.Second, resetAt))
+	}
+	for i := 0; i < count; i++ {
+		usage.Calls = append(usage.Calls, txTimestamp.Seconds)
+	}
+
+	dataJSONasBytes, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(key), redactRecord(dataJSONasBytes))
+	return stub.PutState(key, dataJSONasBytes)
+}
+
+func getQuotaUsage(stub shim.ChaincodeStubInterface, ownerId string, function string) (string, QuotaUsage, error) {
+	key := "Quota_" + ownerId + "_" + function
+	usage := QuotaUsage{"Quota", ownerId, function, []int64{}}
+	usageJSONasBytes, err := stub.GetState(key)
+	if err != nil {
+		return key, usage, err
+	}
+	if usageJSONasBytes != nil {
+		err = json.Unmarshal(usageJSONasBytes, &usage)
+	}
+	return key, usage, err
+}
+
+// callsInWindow drops the calls which are out of the window ending at the txTimestamp.
+func callsInWindow(calls []int64, rule QuotaRule, txTimestamp pb_timestamp.Timestamp) []int64 {
+	var inWindow []int64
+	for _, call := range calls {
+		if call > txTimestamp.Seconds-rule.WindowSeconds {
+			inWindow = append(inWindow, call)
+		}
+	}
+	return inWindow
+}
+
+// windowResetAt is the time when the oldest call leaves the window.
+func windowResetAt(calls []int64, rule QuotaRule) int64 {
+	oldest := calls[0]
+	for _, call := range calls {
+		if call < oldest {
+			oldest = call
+		}
+	}
+	return oldest + rule.WindowSeconds
+}
+
+// countOpenSessions counts the unfinished OnBoarding started by the owner, or the unfinished PanelRequest sponsored by the owner.
+func countOpenSessions(stub shim.ChaincodeStubInterface, ownerId string, function string) (int, error) {
+	var queryString string
+	switch function {
+	case "OnBoarding":
+		queryString = fmt.Sprintf("{\"selector\":{\"operationType\":\"OnBoarding\",\"isFinished\":false,\"owner\":\"%s\"}}", ownerId)
+	case "PanelRequest":
+		queryString = fmt.Sprintf("{\"selector\":{\"operationType\":\"PanelRequest\",\"isFinished\":false,\"sponsor\":\"%s\"}}", ownerId)
+	default:
+		return 0, nil
+	}
+	resultsIterator, err := stub.GetQueryResult(queryString)
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+	open := 0
+	for resultsIterator.HasNext() {
+		_, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+		open++
+	}
+	return open, nil
+}
+
+// ============================================================================================================================
+// GetQuota - query the quota of current owner, for all the functions in quotaFunctions or only the one given.
+// ============================================================================================================================
+func (t *AdChainChaincode) GetQuota(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	//-------------1 optional parameter------------
+	//     0(optional)
+	//   "Function"
+
+	functions := quotaFunctions
+	if len(args) > 0 && len(args[0]) > 0 {
+		if !containsString(quotaFunctions, args[0]) {
+			return shim.Error(fmt.Sprintf("1st argument must be one of: %s.", strings.Join(quotaFunctions, ", ")))
+		}
+		functions = []string{args[0]}
+	}
+
+	ownerId, err := generateOwnerIdByCert(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	config, err := getQuotaConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var statuses []QuotaStatus
+	for _, function := range functions {
+		rule := config.quotaRule(ownerId, function)
+		status := QuotaStatus{function, rule, 0, -1, pb_timestamp.Timestamp{0, 0}, 0}
+		if rule.Limit > 0 {
+			_, usage, err := getQuotaUsage(stub, ownerId, function)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			calls := callsInWindow(usage.Calls, rule, txTimestamp)
+			status.Used = len(calls)
+			status.Remaining = rule.Limit - len(calls)
+			if status.Remaining < 0 {
+				status.Remaining = 0
+			}
+			if len(calls) > 0 {
+				status.ResetAt = pb_timestamp.Timestamp{Seconds: windowResetAt(calls, rule)}
+			}
+		}
+		if rule.MaxOpen > 0 {
+			status.Open, err = countOpenSessions(stub, ownerId, function)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+		statuses = append(statuses, status)
+	}
+
+	statusesJSONasBytes, err := json.Marshal(statuses)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(statusesJSONasBytes)
+}
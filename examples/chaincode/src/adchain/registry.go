@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Registry config schema names the org registry chaincode which adchain asks whether an owner exists and who it is.
+// The registry must answer the "Query" function with a CouchDB selector like fcw_example and adchain do, so any of them
+// can serve as the registry. An empty ChaincodeName means the local mode, only the local OrgRegister records are used.
+// To store this data the key will be: "RegistryConfig"
+type RegistryConfig struct {
+	ChaincodeName string `json:"chaincodeName"`
+	Channel       string `json:"channel"` //empty means the channel of adchain
+}
+
+const registryConfigKey = "RegistryConfig"
+
+// localRegistry is the OrgRegistry argument of Init which switches back to the local mode.
+const localRegistry = "local"
+
+// ========================================================
+// initRegistryConfig is called by Init with the optional "OrgRegistry" argument: <chaincodeName> or <chaincodeName>:<channel>.
+// Init without it keeps the stored config, localRegistry switches back to the local mode.
+// ========================================================
+func initRegistryConfig(stub shim.ChaincodeStubInterface, orgRegistry string) error {
+	if len(orgRegistry) == 0 {
+		return nil
+	}
+	var config RegistryConfig
+	if orgRegistry != localRegistry {
+		list := strings.SplitN(orgRegistry, ":", 2)
+		config.ChaincodeName = list[0]
+		if len(list) == 2 {
+			config.Channel = list[1]
+		}
+		if len(config.ChaincodeName) == 0 {
+			return errors.New("OrgRegistry argument must be <chaincodeName> or <chaincodeName>:<channel>.")
+		}
+	}
+	configJSONasBytes, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(registryConfigKey, configJSONasBytes)
+}
+
+func getRegistryConfig(stub shim.ChaincodeStubInterface) (RegistryConfig, error) {
+	var config RegistryConfig
+	configJSONasBytes, err := stub.GetState(registryConfigKey)
+	if err != nil {
+		return config, err
+	}
+	if configJSONasBytes != nil {
+		err = json.Unmarshal(configJSONasBytes, &config)
+	}
+	return config, err
+}
+
+// ============================================================================================================================
+// queryOrgRegistry asks the registry chaincode for the OrgRegister record of the owner, nil is returned if it is not there.
+// The record is upgraded to the currentSchemaVersion, so the fields the registry does not know get their defaults.
+// ============================================================================================================================
+func queryOrgRegistry(stub shim.ChaincodeStubInterface, config RegistryConfig, ownerId string) (*OrgRegistering, error) {
+	queryString := fmt.Sprintf("{\"selector\":{\"operationType\":\"OrgRegister\",\"owner\":\"%s\"}}", ownerId)
+	newTxLogger(stub).Debugf("- queryOrgRegistry chaincode:%s, queryString:%s", config.ChaincodeName, redactRecord([]byte(queryString)))
+
+	response := stub.InvokeChaincode(config.ChaincodeName, [][]byte{[]byte("Query"), []byte(queryString)}, config.Channel)
+	if response.Status != shim.OK {
+		return nil, errors.New(fmt.Sprintf("Failed to query the org registry chaincode:%s, err:%s", config.ChaincodeName, response.Message))
+	}
+	var queryResultArray []struct {
+		Key    string          `json:"Key"`
+		Record json.RawMessage `json:"Record"`
+	}
+	err := json.Unmarshal(response.Payload, &queryResultArray)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("The org registry chaincode:%s returned an unexpected result, err:%s", config.ChaincodeName, err))
+	}
+	if len(queryResultArray) == 0 {
+		return nil, nil
+	}
+	if len(queryResultArray) > 1 {
+		return nil, errors.New(fmt.Sprintf("The owner:%s has duplicated OrgRegister records in the org registry.", ownerId))
+	}
+	record, _, err := upgradeRecord(queryResultArray[0].Record)
+	if err != nil {
+		return nil, err
+	}
+	var org OrgRegistering
+	err = json.Unmarshal(record, &org)
+	if err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
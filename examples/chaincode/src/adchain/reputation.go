@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	pb_timestamp "github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Reputation schema adds up the outcomes of the OnBoarding sessions an owner took part in, it is not stored on chain.
+// The outcomes are written as ReputationDelta records and added up on read, so the sessions of a popular owner never
+// conflict on one key. The score is computed from it by GetReputation.
+type Reputation struct {
+	Owner                  string                 `json:"owner"`
+	Finished               int                    `json:"finished"`               //sessions finished with this owner as a party
+	Abandoned              int                    `json:"abandoned"`              //sessions abandoned while it was this owner's turn, see SweepAbandoned
+	TotalCompletionSeconds int64                  `json:"totalCompletionSeconds"` //sum of the time from step 1 to the finished step
+	DisputesAgainst        int                    `json:"disputesAgainst"`        //steps of this owner disputed by the counterparty
+	DisputesRaised         int                    `json:"disputesRaised"`         //steps of the counterparty disputed by this owner
+	ConsistentReports      int                    `json:"consistentReports"`      //steps whose filteredLineCount did not grow
+	InconsistentReports    int                    `json:"inconsistentReports"`    //steps whose filteredLineCount grew over the previous one
+	LastUpdatedTimestamp   pb_timestamp.Timestamp `json:"lastUpdatedTimestamp"`
+}
+
+// Reputation score schema is returned to the client by GetReputation, it is not stored on chain.
+// Each component is between 0 and 1, the score is their weighted sum scaled to 0-100.
+type ReputationScore struct {
+	Reputation               Reputation          `json:"reputation"`
+	Score                    float64             `json:"score"`
+	Components               ReputationComponent `json:"components"`
+	AverageCompletionSeconds int64               `json:"averageCompletionSeconds"`
+}
+
+type ReputationComponent struct {
+	Completion  float64 `json:"completion"`  //finished against abandoned sessions
+	Speed       float64 `json:"speed"`       //1 for instant completion, 0.5 for one day on average
+	Disputes    float64 `json:"disputes"`    //1 if none of the reported steps were disputed
+	Consistency float64 `json:"consistency"` //consistent against inconsistent reports
+}
+
+// reputationWeights of completion, speed, disputes and consistency.
+var reputationWeights = [4]float64{0.4, 0.2, 0.2, 0.2}
+
+// an unfinished OnBoarding without any step for abandonAfterSeconds is abandoned by the party whose turn it was.
+const abandonAfterSeconds = 7 * secondsPerDay
+
+const defaultAbandonPageSize = 100
+const maxAbandonPageSize = 500
+
+// Abandon result schema is returned to the client by SweepAbandoned.
+type AbandonResult struct {
+	Abandoned []string `json:"abandoned"` //keys of the OnBoarding which have been marked as abandoned in this call
+	HasMore   bool     `json:"hasMore"`   //call SweepAbandoned again if true
+}
+
+// Reputation delta schema is the outcome of one transaction for one owner. It is only ever written once, without reading
+// anything, so concurrent sessions with the same owner do not conflict.
+// To store this data the key will be: "ReputationDelta_" + ownerId + "_" + TxID
+type ReputationDelta struct {
+	OperationType          string                 `json:"operationType"` //always ReputationDelta
+	SchemaVersion          int                    `json:"schemaVersion"` //schemaVersion is the version of this schema when the record was written, see currentSchemaVersion
+	Owner                  string                 `json:"owner"`
+	TxID                   string                 `json:"txID"`
+	Finished               int                    `json:"finished"`
+	Abandoned              int                    `json:"abandoned"`
+	TotalCompletionSeconds int64                  `json:"totalCompletionSeconds"`
+	DisputesAgainst        int                    `json:"disputesAgainst"`
+	DisputesRaised         int                    `json:"disputesRaised"`
+	ConsistentReports      int                    `json:"consistentReports"`
+	InconsistentReports    int                    `json:"inconsistentReports"`
+	Timestamp              pb_timestamp.Timestamp `json:"timestamp"`
+	UpdatedBy              string                 `json:"updatedBy,omitempty"` //ownerId of the identity which wrote this value, see GetRecordHistory
+}
+
+// reputationDeltas collects the changes of each owner within a transaction, so each owner gets one delta per transaction.
+type reputationDeltas map[string]*ReputationDelta
+
+func (deltas reputationDeltas) of(ownerId string) *ReputationDelta {
+	if deltas[ownerId] == nil {
+		deltas[ownerId] = &ReputationDelta{OperationType: "ReputationDelta", Owner: ownerId}
+	}
+	return deltas[ownerId]
+}
+
+// ============================================================================================================================
+// getReputation adds up all the deltas of the owner.
+// ============================================================================================================================
+func getReputation(stub shim.ChaincodeStubInterface, ownerId string) (Reputation, error) {
+	reputation := Reputation{Owner: ownerId}
+
+	//"`" is the next character after "_", so the range covers every delta of the owner
+	prefix := "ReputationDelta" + "_" + ownerId
+	resultsIterator, err := stub.GetStateByRange(prefix+"_", prefix+"`")
+	if err != nil {
+		return reputation, err
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return reputation, err
+		}
+		var delta ReputationDelta
+		err = json.Unmarshal(queryResponse.Value, &delta)
+		if err != nil {
+			return reputation, err
+		}
+		reputation.Finished += delta.Finished
+		reputation.Abandoned += delta.Abandoned
+		reputation.TotalCompletionSeconds += delta.TotalCompletionSeconds
+		reputation.DisputesAgainst += delta.DisputesAgainst
+		reputation.DisputesRaised += delta.DisputesRaised
+		reputation.ConsistentReports += delta.ConsistentReports
+		reputation.InconsistentReports += delta.InconsistentReports
+		if isBefore(reputation.LastUpdatedTimestamp, delta.Timestamp) {
+			reputation.LastUpdatedTimestamp = delta.Timestamp
+		}
+	}
+	return reputation, nil
+}
+
+// ============================================================================================================================
+// applyReputationDeltas writes the collected changes as one delta record for each owner.
+// ============================================================================================================================
+func applyReputationDeltas(stub shim.ChaincodeStubInterface, deltas reputationDeltas) error {
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	txID := stub.GetTxID()
+	for ownerId, delta := range deltas {
+		key := "ReputationDelta" + "_" + ownerId + "_" + txID
+		delta.SchemaVersion = currentSchemaVersion
+		delta.TxID = txID
+		delta.Timestamp = txTimestamp
+
+		dataJSONasBytes, err := json.Marshal(delta)
+		if err != nil {
+			return err
+		}
+		dataJSONasBytes, err = stampWriter(stub, dataJSONasBytes)
+		if err != nil {
+			return err
+		}
+		newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(key), redactRecord(dataJSONasBytes))
+		err = stub.PutState(key, dataJSONasBytes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ========================================================
+// recordStepOutcome collects the reputation changes of an OnBoarding step. The reported filteredLineCount is consistent
+// if it does not grow over the previous one(the lineCount of the data for step 1), because each step only filters lines out.
+// ========================================================
+func recordStepOutcome(deltas reputationDeltas, record *OnBoarding, previousLineCount int) {
+	if record.FilteredLineCount <= previousLineCount {
+		deltas.of(record.ActingParty).ConsistentReports++
+	} else {
+		deltas.of(record.ActingParty).InconsistentReports++
+	}
+	if record.IsFinished {
+		seconds := record.Timestamp.Seconds - record.StartTimestamp.Seconds
+		for _, party := range uniqueStrings(record.Owner, record.TargetOwner) {
+			deltas.of(party).Finished++
+			deltas.of(party).TotalCompletionSeconds += seconds
+		}
+	}
+}
+
+// registeredLineCount returns the lineCount of the data, it is the upper bound of the filteredLineCount of step 1.
+func registeredLineCount(stub shim.ChaincodeStubInterface, ownerId string, dataName string) (int, error) {
+	queryResults, err := queryByDataAndOperationType(stub, "DataRegister", ownerId, dataName)
+	if err != nil {
+		return 0, err
+	}
+	var queryResult_DataRegistering_Array QueryResult_DataRegistering_Array
+	err = json.Unmarshal(queryResults, &queryResult_DataRegistering_Array)
+	if err != nil {
+		return 0, err
+	}
+	if len(queryResult_DataRegistering_Array) != 1 {
+		return 0, errors.New(fmt.Sprintf("The data:%s of owner:%s doesn't have exactly one DataRegister record.", dataName, ownerId))
+	}
+	return queryResult_DataRegistering_Array[0].Record.LineCount, nil
+}
+
+func uniqueStrings(values ...string) []string {
+	var unique []string
+	for _, value := range values {
+		if !containsString(unique, value) {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+// ============================================================================================================================
+// SweepAbandoned marks the unfinished OnBoarding without any step for abandonAfterSeconds as abandoned, and counts it
+// against the party whose turn it was(the counterparty of the last acting party). Anyone can call it like SweepExpired.
+// A disputed step is waiting for the parties to settle, not for a turn, so the disputed OnBoarding is never swept.
+// ============================================================================================================================
+func (t *AdChainChaincode) SweepAbandoned(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	//-------------1 optional parameter------------
+	//     0(optional)
+	//   "PageSize"
+
+	pageSize := defaultAbandonPageSize
+	if len(args) > 0 && len(args[0]) > 0 {
+		var err error
+		pageSize, err = strconv.Atoi(args[0])
+		if err != nil || pageSize < 1 || pageSize > maxAbandonPageSize {
+			return shim.Error(fmt.Sprintf("1st argument must be a numeric string from 1 to %d as pageSize of SweepAbandoned.", maxAbandonPageSize))
+		}
+	}
+
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	//one more than the page is asked for to know whether there are more
+	queryString := fmt.Sprintf("{\"selector\":{\"operationType\":\"OnBoarding\",\"isFinished\":false,\"isAbandoned\":{\"$ne\":true},\"dispute\":{\"$exists\":false},\"timestamp.seconds\":{\"$lte\":%d}},\"limit\":%d}",
+		txTimestamp.Seconds-abandonAfterSeconds, pageSize+1)
+	queryResults, err := getQueryResultForQueryString(stub, queryString)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var onBoardingArray []struct {
+		Key    string     `json:"Key"`
+		Record OnBoarding `json:"Record"`
+	}
+	err = json.Unmarshal(queryResults, &onBoardingArray)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	result := AbandonResult{[]string{}, false}
+	deltas := reputationDeltas{}
+	for _, queryResult := range onBoardingArray {
+		if len(result.Abandoned) == pageSize {
+			result.HasMore = true
+			break
+		}
+		record := queryResult.Record
+		if record.Dispute != nil {
+			continue
+		}
+		record.IsAbandoned = true
+		//the records written before acting parties were verified can not tell whose turn it was
+		if len(record.ActingParty) > 0 {
+			waitingParty := record.Owner
+			if record.ActingParty == record.Owner {
+				waitingParty = record.TargetOwner
+			}
+			deltas.of(waitingParty).Abandoned++
+		}
+		err = putFlaggedRecord(stub, queryResult.Key, record)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		result.Abandoned = append(result.Abandoned, queryResult.Key)
+	}
+	err = applyReputationDeltas(stub, deltas)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	newTxLogger(stub).Infof("SweepAbandoned marked %d OnBoarding as abandoned", len(result.Abandoned))
+	resultJSONasBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultJSONasBytes)
+}
+
+// ============================================================================================================================
+// GetReputation - query the reputation of an owner, with the score and its components.
+// ============================================================================================================================
+func (t *AdChainChaincode) GetReputation(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	//-------------1 parameter------------
+	//     0
+	//   "OwnerId"
+
+	if len(args) != 1 || len(args[0]) == 0 {
+		return shim.Error("Incorrect number of arguments. Expecting ownerId for GetReputation")
+	}
+	ownerId := strings.ToLower(args[0])
+	org, _, err := getOrgRegistering(stub, ownerId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if org == nil {
+		return shim.Error(fmt.Sprintf("The owner:%s has not registered yet.", ownerId))
+	}
+	reputation, err := getReputation(stub, ownerId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	scoreJSONasBytes, err := json.Marshal(scoreReputation(reputation))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(scoreJSONasBytes)
+}
+
+// ========================================================
+// scoreReputation computes the components with one success and one failure as prior, so a new owner starts in the middle
+// instead of at either end.
+// ========================================================
+func scoreReputation(reputation Reputation) ReputationScore {
+	score := ReputationScore{Reputation: reputation}
+	reports := reputation.ConsistentReports + reputation.InconsistentReports
+
+	score.Components.Completion = float64(reputation.Finished+1) / float64(reputation.Finished+reputation.Abandoned+2)
+	score.Components.Consistency = float64(reputation.ConsistentReports+1) / float64(reports+2)
+	score.Components.Disputes = 1 - float64(reputation.DisputesAgainst)/float64(reports+reputation.DisputesAgainst+1)
+	score.Components.Speed = 0.5
+	if reputation.Finished > 0 {
+		score.AverageCompletionSeconds = reputation.TotalCompletionSeconds / int64(reputation.Finished)
+		score.Components.Speed = 1 / (1 + float64(score.AverageCompletionSeconds)/secondsPerDay)
+	}
+
+	score.Score = 100 * (reputationWeights[0]*score.Components.Completion +
+		reputationWeights[1]*score.Components.Speed +
+		reputationWeights[2]*score.Components.Disputes +
+		reputationWeights[3]*score.Components.Consistency)
+	return score
+}
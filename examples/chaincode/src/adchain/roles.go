@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Roles are taken from the OU of the cert subject, or from the "adchain.roles" attribute(comma separated)
+// which fabric-ca puts into the cert when the identity is registered with --id.attrs.
+// Every org runs its own CA, so the privileged roles(admin and auditor) are only honored for the MSPs listed in RolesConfig.
+const (
+	roleAdmin        = "admin"
+	roleAuditor      = "auditor"
+	roleDataProvider = "data-provider"
+	roleSponsor      = "sponsor"
+)
+
+var allRoles = []string{roleAdmin, roleAuditor, roleDataProvider, roleSponsor}
+
+// defaultRoles are given to a cert which carries none of the roles, so the members enrolled before roles were
+// introduced can still provide data and sponsor panels. admin and auditor always have to be granted explicitly.
+var defaultRoles = []string{roleDataProvider, roleSponsor}
+
+// Roles config schema lists the MSPs whose certs may carry the privileged roles.
+// To store this data the key will be: "RolesConfig"
+type RolesConfig struct {
+	AdminOrgs   []string `json:"adminOrgs"`   //MSP ids allowed to hold the admin role
+	AuditorOrgs []string `json:"auditorOrgs"` //MSP ids allowed to hold the auditor role
+}
+
+const rolesConfigKey = "RolesConfig"
+
+// fabric-ca stores the attributes as {"attrs":{"name":"value"}} in this extension.
+var fabricCAAttrsOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+const rolesAttributeName = "adchain.roles"
+
+// functionRoles declares which roles may call each function, the function is allowed if the caller has any of them.
+var functionRoles = map[string][]string{
+	"Query":             allRoles,
+	"WhoAmI":            allRoles,
+	"OrgRegister":       allRoles,
+	"DataRegister":      {roleDataProvider},
+	"DataRegisterBatch": {roleDataProvider},
+	"OnBoarding":        {roleDataProvider, roleSponsor},
+	"PanelRequest":      {roleSponsor},
+	"PanelUpdate":       {roleDataProvider},
+	"Migrate":           {roleAdmin},
+	"ExportState":       {roleAdmin},
+	"ImportState":       {roleAdmin},
+	"ApproveImport":     {roleAdmin},
+	"CloseImport":       {roleAdmin},
+	"Propose":           allRoles,
+	"Vote":              allRoles,
+	"GetProposal":       allRoles,
+	"ListProposals":     allRoles,
+	"GetRecordHistory":  allRoles,
+	"SweepExpired":      allRoles,
+	"QueryExpiringData": allRoles,
+	"VerifyArtifact":    allRoles,
+	"OnBoardingAttest":  {roleDataProvider, roleSponsor},
+	"GetQuota":          allRoles,
+	"OnBoardingDispute": {roleDataProvider, roleSponsor},
+	"SweepAbandoned":    allRoles,
+	"GetReputation":     allRoles,
+	"OrgSuspend":        {roleAdmin},
+	"OrgReinstate":      {roleAdmin},
+	"OrgDeregister":     {roleAdmin},
+	"GetConfig":         allRoles,
+	"UpdateConfig":      {roleAdmin},
+}
+
+// ========================================================
+// getRolesFromCert collects the roles from the OU and the fabric-ca attributes of the cert
+// ========================================================
+func getRolesFromCert(cert *x509.Certificate) ([]string, error) {
+	var roles []string
+	for _, ou := range cert.Subject.OrganizationalUnit {
+		roles = appendRole(roles, ou)
+	}
+
+	for _, extension := range cert.Extensions {
+		if !extension.Id.Equal(fabricCAAttrsOID) {
+			continue
+		}
+		var attrs struct {
+			Attrs map[string]string `json:"attrs"`
+		}
+		err := json.Unmarshal(extension.Value, &attrs)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Failed to parse fabric-ca attributes of cert, err %s", err))
+		}
+		for _, role := range strings.Split(attrs.Attrs[rolesAttributeName], ",") {
+			roles = appendRole(roles, role)
+		}
+	}
+
+	if len(roles) == 0 {
+		roles = append(roles, defaultRoles...)
+	}
+	return roles, nil
+}
+
+// appendRole only keeps the known roles, and each of them once.
+func appendRole(roles []string, role string) []string {
+	role = strings.ToLower(strings.TrimSpace(role))
+	if !containsString(allRoles, role) || containsString(roles, role) {
+		return roles
+	}
+	return append(roles, role)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ========================================================
+// checkFunctionRoles returns error if the caller has none of the roles declared for the function.
+// A function without declared roles is denied, so a new function can not be called until it is added to functionRoles.
+// ========================================================
+func checkFunctionRoles(stub shim.ChaincodeStubInterface, function string) error {
+	allowedRoles, ok := functionRoles[function]
+	if !ok {
+		newTxLogger(stub).Warningf("Function %s has no roles declared", function)
+		return errors.New(fmt.Sprintf("Function %s has no roles declared, it can not be called.", function))
+	}
+	roles, err := getRoles(stub)
+	if err != nil {
+		return err
+	}
+	for _, role := range roles {
+		if containsString(allowedRoles, role) {
+			return nil
+		}
+	}
+	newTxLogger(stub).Warningf("Caller with roles:%s is not allowed to call %s", strings.Join(roles, ","), function)
+	return errors.New(fmt.Sprintf("Caller with roles:%s is not allowed to call %s, expecting one of roles:%s.",
+		strings.Join(roles, ","), function, strings.Join(allowedRoles, ",")))
+}
+
+// ========================================================
+// filterPrivilegedRoles drops admin and auditor unless the MSP of the caller is allowed to hold them by RolesConfig.
+// The default roles are given if no role is left.
+// ========================================================
+func filterPrivilegedRoles(stub shim.ChaincodeStubInterface, roles []string, mspId string) ([]string, error) {
+	config, err := getRolesConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	var filtered []string
+	for _, role := range roles {
+		if role == roleAdmin && !containsString(config.AdminOrgs, mspId) ||
+			role == roleAuditor && !containsString(config.AuditorOrgs, mspId) {
+			newTxLogger(stub).Warningf("Role %s of the cert is ignored, MSP:%s is not allowed to hold it", role, mspId)
+			continue
+		}
+		filtered = append(filtered, role)
+	}
+	if len(filtered) == 0 {
+		filtered = append(filtered, defaultRoles...)
+	}
+	return filtered, nil
+}
+
+// ========================================================
+// initRolesConfig is called by Init with the optional "AdminOrgs" and "AuditorOrgs" arguments(comma separated MSP ids).
+// An argument left out keeps the stored list, a new instance gives both roles to the MSP of the instantiator.
+// ========================================================
+func initRolesConfig(stub shim.ChaincodeStubInterface, adminOrgs string, auditorOrgs string) error {
+	configJSONasBytes, err := stub.GetState(rolesConfigKey)
+	if err != nil {
+		return err
+	}
+	var config RolesConfig
+	if configJSONasBytes != nil {
+		err = json.Unmarshal(configJSONasBytes, &config)
+		if err != nil {
+			return err
+		}
+	} else {
+		mspId, err := getMspId(stub)
+		if err != nil {
+			return err
+		}
+		config = RolesConfig{[]string{mspId}, []string{mspId}}
+	}
+	if len(adminOrgs) > 0 {
+		config.AdminOrgs = splitMspIds(adminOrgs)
+	}
+	if len(auditorOrgs) > 0 {
+		config.AuditorOrgs = splitMspIds(auditorOrgs)
+	}
+	if len(config.AdminOrgs) == 0 {
+		return errors.New("AdminOrgs argument must name at least one MSP id.")
+	}
+	return putRolesConfig(stub, config)
+}
+
+func getRolesConfig(stub shim.ChaincodeStubInterface) (RolesConfig, error) {
+	var config RolesConfig
+	configJSONasBytes, err := stub.GetState(rolesConfigKey)
+	if err != nil {
+		return config, err
+	}
+	if configJSONasBytes != nil {
+		err = json.Unmarshal(configJSONasBytes, &config)
+	}
+	return config, err
+}
+
+func putRolesConfig(stub shim.ChaincodeStubInterface, config RolesConfig) error {
+	configJSONasBytes, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", rolesConfigKey, configJSONasBytes)
+	return stub.PutState(rolesConfigKey, configJSONasBytes)
+}
+
+func splitMspIds(list string) []string {
+	mspIds := []string{}
+	for _, mspId := range strings.Split(list, ",") {
+		mspId = strings.TrimSpace(mspId)
+		if len(mspId) > 0 && !containsString(mspIds, mspId) {
+			mspIds = append(mspIds, mspId)
+		}
+	}
+	return mspIds
+}
+
+// SetRoleOrgs takes the parameters: "Role"(admin or auditor) "Orgs"(comma separated MSP ids)
+func validateRoleOrgsParams(stub shim.ChaincodeStubInterface, params []string) error {
+	if len(params) != 2 || (params[0] != roleAdmin && params[0] != roleAuditor) {
+		return errors.New("Incorrect params. Expecting Role(admin or auditor) and Orgs.")
+	}
+	if params[0] == roleAdmin && len(splitMspIds(params[1])) == 0 {
+		return errors.New("Incorrect params. The admin role must be given to at least one MSP id.")
+	}
+	return nil
+}
+
+func applySetRoleOrgs(stub shim.ChaincodeStubInterface, params []string, updatedBy string) error {
+	config, err := getRolesConfig(stub)
+	if err != nil {
+		return err
+	}
+	if params[0] == roleAdmin {
+		config.AdminOrgs = splitMspIds(params[1])
+	} else {
+		config.AuditorOrgs = splitMspIds(params[1])
+	}
+	newTxLogger(stub).Infof("The %s orgs are %s, updated by %s", params[0], params[1], updatedBy)
+	return putRolesConfig(stub, config)
+}
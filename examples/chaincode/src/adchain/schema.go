@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// currentSchemaVersion is stamped into every record written by this chaincode.
+// Records written before versioning was introduced have no schemaVersion field, which is read as version 0.
+// Bump it together with a new entry in schemaUpgrades whenever a stored schema changes shape.
+const currentSchemaVersion = 6
+
+// schemaUpgrades[operationType][v] upgrades a record of version v to version v+1.
+// Upgrades work on the generic json map, so they still apply after the Go struct has changed.
+var schemaUpgrades = map[string][]func(record map[string]interface{}) error{
+	"OrgRegister":     {upgradeNothing, upgradeOrgRegisteringV1ToV2, upgradeNothing, upgradeOrgRegisteringV3ToV4, upgradeNothing, upgradeNothing},
+	"DataRegister":    {upgradeDataRegisteringV0ToV1, upgradeNothing, upgradeDataRegisteringV2ToV3, upgradeNothing, upgradeNothing, upgradeNothing},
+	"OnBoarding":      {upgradeNothing, upgradeNothing, upgradeNothing, upgradeNothing, upgradeOnBoardingV4ToV5, upgradeOnBoardingV5ToV6},
+	"PanelRequest":    {upgradePanelingV0ToV1, upgradeNothing, upgradeNothing, upgradeNothing, upgradeNothing, upgradeNothing},
+	"Propose":         {upgradeNothing, upgradeNothing, upgradeNothing, upgradeNothing, upgradeNothing, upgradeNothing}, //introduced in version 2
+	"ReputationDelta": {upgradeNothing, upgradeNothing, upgradeNothing, upgradeNothing, upgradeNothing, upgradeNothing}, //introduced in version 6
+}
+
+// Migrate result schema is returned to the client after each batch.
+type MigrateResult struct {
+	Scanned  int    `json:"scanned"`  //how many keys have been visited in this batch
+	Migrated int    `json:"migrated"` //how many records have been rewritten in this batch
+	Bookmark string `json:"bookmark"` //pass it to the next Migrate call, empty when all the keys have been visited
+}
+
+const defaultMigratePageSize = 100
+
+// upgradeNothing is used when the schema did not change between the versions, or only got fields which default to empty.
+func upgradeNothing(record map[string]interface{}) error {
+	return nil
+}
+
+// DataRegistering written by the fcw_example shape has no tag, field and matching statistics.
+func upgradeDataRegisteringV0ToV1(record map[string]interface{}) error {
+	setDefault(record, "tag", "")
+	setDefault(record, "field", "")
+	setDefault(record, "matchCount", 0)
+	setDefault(record, "lastMatchTimestamp", map[string]interface{}{})
+	return nil
+}
+
+// Paneling written before PanelUpdate existed has no lastUpdatedTimestamp.
+func upgradePanelingV0ToV1(record map[string]interface{}) error {
+	setDefault(record, "lastUpdatedTimestamp", map[string]interface{}{})
+	return nil
+}
+
+// OrgRegistering registered before suspension was introduced is active.
+func upgradeOrgRegisteringV1ToV2(record map[string]interface{}) error {
+	setDefault(record, "status", orgStatusActive)
+	setDefault(record, "statusReason", "")
+	setDefault(record, "statusUpdatedBy", "")
+	setDefault(record, "statusTimestamp", map[string]interface{}{})
+	return nil
+}
+
+// DataRegistering registered before expiry was introduced never expires.
+func upgradeDataRegisteringV2ToV3(record map[string]interface{}) error {
+	setDefault(record, "expiryTimestamp", map[string]interface{}{})
+	setDefault(record, "isExpired", false)
+	return nil
+}
+
+// OrgRegistering registered before public keys were kept has an empty one, OrgRegister fills it in.
+func upgradeOrgRegisteringV3ToV4(record map[string]interface{}) error {
+	setDefault(record, "publicKey", "")
+	return nil
+}
+
+// OnBoarding written before acting parties were verified only knows that step 1 is submitted by the owner.
+func upgradeOnBoardingV4ToV5(record map[string]interface{}) error {
+	if step, ok := record["step"].(float64); ok && step == 1 {
+		setDefault(record, "actingParty", record["owner"])
+	}
+	setDefault(record, "actingParty", "")
+	return nil
+}
+
+// OnBoarding written before reputation was kept takes the time of its latest step as the start, and is not abandoned.
+func upgradeOnBoardingV5ToV6(record map[string]interface{}) error {
+	setDefault(record, "startTimestamp", record["timestamp"])
+	setDefault(record, "isAbandoned", false)
+	return nil
+}
+
+func setDefault(record map[string]interface{}, field string, value interface{}) {
+	if _, ok := record[field]; !ok {
+		record[field] = value
+	}
+}
+
+// ============================================================================================================================
+// upgradeRecord brings a stored record to the currentSchemaVersion.
+// Values which are not records of this chaincode(no known operationType) are returned as is.
+// Return the upgraded value and whether it is different from the stored one.
+// ============================================================================================================================
+func upgradeRecord(value []byte) ([]byte, bool, error) {
+	var record map[string]interface{}
+	if json.Unmarshal(value, &record) != nil {
+		return value, false, nil
+	}
+	operationType, _ := record["operationType"].(string)
+	upgrades, ok := schemaUpgrades[operationType]
+	if !ok {
+		return value, false, nil
+	}
+
+	version := 0
+	if v, ok := record["schemaVersion"].(float64); ok {
+		version = int(v)
+	}
+	if version > currentSchemaVersion {
+		return nil, false, errors.New(fmt.Sprintf("Record schemaVersion:%d is newer than the chaincode schemaVersion:%d, please upgrade the chaincode.", version, currentSchemaVersion))
+	}
+	if version == currentSchemaVersion {
+		return value, false, nil
+	}
+
+	for ; version < currentSchemaVersion; version++ {
+		err := upgrades[version](record)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	record["schemaVersion"] = currentSchemaVersion
+
+	upgradedJSONasBytes, err := json.Marshal(record)
+	if err != nil {
+		return nil, false, err
+	}
+	return upgradedJSONasBytes, true, nil
+}
+
+// ============================================================================================================================
+// Migrate rewrites the records which are older than currentSchemaVersion, it should be called in batches after a chaincode upgrade
+// until the returned bookmark is empty. Reading works without Migrate, because all readers upgrade records on the fly.
+// ============================================================================================================================
+func (t *AdChainChaincode) Migrate(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	//-------------2 optional parameters------------
+	//     0(optional)       1(optional)
+	//   "PageSize"       "Bookmark"
+
+	pageSize := defaultMigratePageSize
+	if len(args) > 0 && len(args[0]) > 0 {
+		var err error
+		pageSize, err = strconv.Atoi(args[0])
+		if err != nil || pageSize < 1 {
+			return shim.Error("1st argument must be a positive numeric string as pageSize of Migrate.")
+		}
+	}
+	var bookmark string
+	if len(args) > 1 {
+		bookmark = args[1]
+	}
+
+	resultsIterator, err := stub.GetStateByRange(bookmark, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var result MigrateResult
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if result.Scanned == pageSize {
+			result.Bookmark = queryResponse.Key
+			break
+		}
+		result.Scanned++
+
+		upgradedJSONasBytes, upgraded, err := upgradeRecord(queryResponse.Value)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Failed to migrate key:%s, err:%s", queryResponse.Key, err))
+		}
+		if !upgraded {
+			continue
+		}
+		upgradedJSONasBytes, err = stampWriter(stub, upgradedJSONasBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(queryResponse.Key), redactRecord(upgradedJSONasBytes))
+		err = stub.PutState(queryResponse.Key, upgradedJSONasBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		result.Migrated++
+	}
+
+	newTxLogger(stub).Infof("Migrate scanned:%d, migrated:%d, bookmark:%s", result.Scanned, result.Migrated, redactKey(result.Bookmark))
+	resultJSONasBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultJSONasBytes)
+}
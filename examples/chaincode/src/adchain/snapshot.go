@@ -0,0 +1,391 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	pb_timestamp "github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// State snapshot schema is the envelope returned by ExportState and accepted by ImportState.
+// The records are exported at the currentSchemaVersion of the exporting chaincode, ImportState accepts the same or older ones.
+type StateSnapshot struct {
+	Format        string                 `json:"format"`        //always adchain-state
+	Version       int                    `json:"version"`       //version of the envelope, see snapshotVersion
+	SchemaVersion int                    `json:"schemaVersion"` //schemaVersion of the records
+	ExportedAt    pb_timestamp.Timestamp `json:"exportedAt"`
+	Entries       []SnapshotEntry        `json:"entries"`
+	Bookmark      string                 `json:"bookmark,omitempty"` //pass it to the next ExportState call, empty on the last page
+}
+
+type SnapshotEntry struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"` //the record as stored, including timestamps, owner ids and updatedBy
+}
+
+// Import window schema tells whether ImportState is allowed. It is opened by Init of a fresh instance only, and closed
+// for good by CloseImport, so records can never be imported into a channel which is already in use.
+// To store this data the key will be: "ImportWindow"
+type ImportWindow struct {
+	Open          bool                   `json:"open"`
+	OpenedAt      pb_timestamp.Timestamp `json:"openedAt"`
+	ClosedAt      pb_timestamp.Timestamp `json:"closedAt"`
+	ImportedTotal int                    `json:"importedTotal"`
+}
+
+// Import approval schema collects the approvals of one snapshot page, ImportState needs a quorum of distinct MSPs
+// (see GovernanceConfig) to approve the sha256 of the exact envelope before it is imported.
+// To store this data the key will be: "ImportApproval" + "_" + Hash
+type ImportApproval struct {
+	Hash      string   `json:"hash"`      //hex of sha256 of the envelope
+	MspIds    []string `json:"mspIds"`    //MSPs of the admins who approved
+	Approvers []string `json:"approvers"` //ownerIds of the admins who approved
+}
+
+const importWindowKey = "ImportWindow"
+
+const snapshotFormat = "adchain-state"
+
+const snapshotVersion = 1
+
+const defaultExportPageSize = 100
+
+// snapshotOperationTypes are the records carried over to a new channel, the configs are given by Init of the new instance.
+var snapshotOperationTypes = []string{"OrgRegister", "DataRegister", "OnBoarding", "PanelRequest", "Propose", "ReputationDelta"}
+
+// ============================================================================================================================
+// ExportState - query a page of every adchain record in a versioned envelope, call it again with the returned bookmark
+// until the bookmark is empty. It is only allowed for admin.
+// ============================================================================================================================
+func (t *AdChainChaincode) ExportState(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	//-------------2 optional parameters------------
+	//     0(optional)       1(optional)
+	//   "PageSize"       "Bookmark"
+
+	pageSize := defaultExportPageSize
+	if len(args) > 0 && len(args[0]) > 0 {
+		var err error
+		pageSize, err = strconv.Atoi(args[0])
+		if err != nil || pageSize < 1 {
+			return shim.Error("1st argument must be a positive numeric string as pageSize of ExportState.")
+		}
+	}
+	var bookmark string
+	if len(args) > 1 {
+		bookmark = args[1]
+	}
+
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := stub.GetStateByRange(bookmark, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	snapshot := StateSnapshot{snapshotFormat, snapshotVersion, currentSchemaVersion, txTimestamp, []SnapshotEntry{}, ""}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if len(snapshot.Entries) == pageSize {
+			snapshot.Bookmark = queryResponse.Key
+			break
+		}
+		if !isSnapshotRecord(queryResponse.Key, queryResponse.Value) {
+			continue
+		}
+		record, _, err := upgradeRecord(queryResponse.Value)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Failed to export key:%s, err:%s", queryResponse.Key, err))
+		}
+		snapshot.Entries = append(snapshot.Entries, SnapshotEntry{queryResponse.Key, record})
+	}
+
+	newTxLogger(stub).Infof("ExportState exported:%d, bookmark:%s", len(snapshot.Entries), redactKey(snapshot.Bookmark))
+	snapshotJSONasBytes, err := json.Marshal(snapshot)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(snapshotJSONasBytes)
+}
+
+// ============================================================================================================================
+// ImportState replays an envelope returned by ExportState into a fresh chaincode instance, it is only allowed for admin.
+// The import window must be open, and the sha256 of the envelope must have been approved by ApproveImport of a quorum of MSPs.
+// The records are written as they are, so the original timestamps and owner ids are kept. If any of the keys already exists
+// nothing is written.
+// ============================================================================================================================
+func (t *AdChainChaincode) ImportState(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	//-------------1 parameter------------
+	//     0
+	//   "Snapshot": the envelope returned by ExportState
+
+	if len(args) != 1 || len(args[0]) == 0 {
+		return shim.Error("Incorrect number of arguments. Expecting the envelope returned by ExportState for ImportState")
+	}
+	var snapshot StateSnapshot
+	err := json.Unmarshal([]byte(args[0]), &snapshot)
+	if err != nil {
+		return shim.Error("1st argument must be the envelope returned by ExportState, err: " + err.Error())
+	}
+	err = checkSnapshotEnvelope(&snapshot)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	window, err := getOpenImportWindow(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	hash := sha256.Sum256([]byte(args[0]))
+	approvalKey := "ImportApproval" + "_" + hex.EncodeToString(hash[:])
+	approval, err := getImportApproval(stub, approvalKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	config, err := getGovernanceConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if approval == nil || len(approval.MspIds) < config.Quorum {
+		return shim.Error(fmt.Sprintf("The snapshot sha256:%s is not approved by a quorum of %d MSPs yet, see ApproveImport.",
+			hex.EncodeToString(hash[:]), config.Quorum))
+	}
+
+	//validate all the entries before writing any of them
+	seen := map[string]bool{}
+	values := make([][]byte, len(snapshot.Entries))
+	for i, entry := range snapshot.Entries {
+		if seen[entry.Key] {
+			return shim.Error(fmt.Sprintf("The key:%s is duplicated in the snapshot.", entry.Key))
+		}
+		seen[entry.Key] = true
+		if !isSnapshotRecord(entry.Key, entry.Value) {
+			return shim.Error(fmt.Sprintf("The key:%s is not an adchain record which can be imported.", entry.Key))
+		}
+		existing, err := stub.GetState(entry.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if existing != nil {
+			return shim.Error(fmt.Sprintf("The key:%s already exists, ImportState never overwrites records.", entry.Key))
+		}
+		values[i], _, err = upgradeRecord(entry.Value)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Failed to import key:%s, err:%s", entry.Key, err))
+		}
+	}
+
+	for i, entry := range snapshot.Entries {
+		newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(entry.Key), redactRecord(values[i]))
+		err = stub.PutState(entry.Key, values[i])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	//the approval is used up, so the same page can not be imported again
+	err = stub.DelState(approvalKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	window.ImportedTotal += len(snapshot.Entries)
+	err = putImportWindow(stub, window)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	newTxLogger(stub).Infof("ImportState imported:%d", len(snapshot.Entries))
+	return shim.Success([]byte(strconv.Itoa(len(snapshot.Entries))))
+}
+
+// ============================================================================================================================
+// ApproveImport is used by an admin to approve one snapshot page for ImportState, each MSP approves once.
+// The number of approving MSPs is returned.
+// ============================================================================================================================
+func (t *AdChainChaincode) ApproveImport(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	//-------------1 parameter------------
+	//     0
+	//   "Hash": hex of sha256 of the envelope which will be passed to ImportState
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting the sha256 of the envelope for ApproveImport")
+	}
+	hash := strings.ToLower(args[0])
+	if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+		return shim.Error("1st argument must be the hex of a sha256 as hash of ApproveImport.")
+	}
+	_, err := getOpenImportWindow(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	ownerId, err := generateOwnerIdByCert(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	mspId, err := getMspId(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key := "ImportApproval" + "_" + hash
+	approval, err := getImportApproval(stub, key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if approval == nil {
+		approval = &ImportApproval{hash, []string{}, []string{}}
+	}
+	if containsString(approval.MspIds, mspId) {
+		return shim.Error(fmt.Sprintf("MSP:%s already approved the snapshot sha256:%s.", mspId, hash))
+	}
+	approval.MspIds = append(approval.MspIds, mspId)
+	approval.Approvers = append(approval.Approvers, ownerId)
+
+	approvalJSONasBytes, err := json.Marshal(approval)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(key), redactRecord(approvalJSONasBytes))
+	err = stub.PutState(key, approvalJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(strconv.Itoa(len(approval.MspIds))))
+}
+
+// ============================================================================================================================
+// CloseImport is used by an admin to close the import window for good once all the pages are imported.
+// ============================================================================================================================
+func (t *AdChainChaincode) CloseImport(stub shim.ChaincodeStubInterface) pb.Response {
+	window, err := getOpenImportWindow(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	window.Open = false
+	window.ClosedAt = txTimestamp
+	err = putImportWindow(stub, window)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	newTxLogger(stub).Infof("CloseImport closed the import window, imported:%d", window.ImportedTotal)
+	return shim.Success(nil)
+}
+
+// ========================================================
+// initImportWindow is called by Init, it opens the import window if the instance is fresh: Init never ran and there is
+// no adchain record yet. An upgrade never opens it again.
+// ========================================================
+func initImportWindow(stub shim.ChaincodeStubInterface, isFirstInit bool) error {
+	windowJSONasBytes, err := stub.GetState(importWindowKey)
+	if err != nil {
+		return err
+	}
+	if windowJSONasBytes != nil || !isFirstInit {
+		return nil
+	}
+	for _, operationType := range snapshotOperationTypes {
+		//"`" is the next character after "_", so the range covers every key starting with operationType + "_"
+		resultsIterator, err := stub.GetStateByRange(operationType+"_", operationType+"`")
+		if err != nil {
+			return err
+		}
+		hasRecord := resultsIterator.HasNext()
+		resultsIterator.Close()
+		if hasRecord {
+			return nil
+		}
+	}
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	return putImportWindow(stub, &ImportWindow{true, txTimestamp, pb_timestamp.Timestamp{0, 0}, 0})
+}
+
+// getOpenImportWindow returns error unless the import window is open.
+func getOpenImportWindow(stub shim.ChaincodeStubInterface) (*ImportWindow, error) {
+	windowJSONasBytes, err := stub.GetState(importWindowKey)
+	if err != nil {
+		return nil, err
+	}
+	var window ImportWindow
+	if windowJSONasBytes != nil {
+		err = json.Unmarshal(windowJSONasBytes, &window)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !window.Open {
+		return nil, errors.New("The import window is not open, records can only be imported into a fresh instance before CloseImport.")
+	}
+	return &window, nil
+}
+
+func putImportWindow(stub shim.ChaincodeStubInterface, window *ImportWindow) error {
+	windowJSONasBytes, err := json.Marshal(window)
+	if err != nil {
+		return err
+	}
+	newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", importWindowKey, windowJSONasBytes)
+	return stub.PutState(importWindowKey, windowJSONasBytes)
+}
+
+func getImportApproval(stub shim.ChaincodeStubInterface, key string) (*ImportApproval, error) {
+	approvalJSONasBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if approvalJSONasBytes == nil {
+		return nil, nil
+	}
+	var approval ImportApproval
+	err = json.Unmarshal(approvalJSONasBytes, &approval)
+	if err != nil {
+		return nil, err
+	}
+	return &approval, nil
+}
+
+func checkSnapshotEnvelope(snapshot *StateSnapshot) error {
+	if snapshot.Format != snapshotFormat {
+		return errors.New(fmt.Sprintf("The snapshot format:%s is not %s.", snapshot.Format, snapshotFormat))
+	}
+	if snapshot.Version != snapshotVersion {
+		return errors.New(fmt.Sprintf("The snapshot version:%d is not supported, expecting %d.", snapshot.Version, snapshotVersion))
+	}
+	if snapshot.SchemaVersion > currentSchemaVersion {
+		return errors.New(fmt.Sprintf("The snapshot schemaVersion:%d is newer than the chaincode schemaVersion:%d, please upgrade the chaincode.",
+			snapshot.SchemaVersion, currentSchemaVersion))
+	}
+	return nil
+}
+
+// isSnapshotRecord returns true for the records of snapshotOperationTypes whose key starts with their operationType.
+func isSnapshotRecord(key string, value []byte) bool {
+	var record struct {
+		OperationType string `json:"operationType"`
+	}
+	if json.Unmarshal(value, &record) != nil {
+		return false
+	}
+	return containsString(snapshotOperationTypes, record.OperationType) && strings.HasPrefix(key, record.OperationType+"_")
+}
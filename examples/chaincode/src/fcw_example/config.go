@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pb_timestamp "github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb_msp "github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Chaincode config schema is validated and written by Init, and replaced by the admin orgs with UpdateConfig.
+// To store this data the key will be: "ChaincodeConfig"
+type ChaincodeConfig struct {
+	Version          int                    `json:"version"`          //increased by every UpdateConfig
+	AdminOrgs        []string               `json:"adminOrgs"`        //MSP ids allowed to call UpdateConfig, never empty
+	Quotas           map[string]QuotaRule   `json:"quotas"`           //function => rule, functions without a rule are unlimited
+	AllowedDataTypes []string               `json:"allowedDataTypes"` //dataTypes accepted by DataRegister, empty means any
+	DataExpiry       string                 `json:"dataExpiry"`       //default expiry of DataRegister as a duration like 720h, empty means never
+	Features         map[string]bool        `json:"features"`         //function => enabled, functions not listed are enabled
+	UpdatedBy        string                 `json:"updatedBy"`        //MSP id of the last writer
+	Timestamp        pb_timestamp.Timestamp `json:"timestamp"`
+}
+
+// Quota rule schema allows Limit calls of a function per owner in any sliding window of WindowSeconds.
+type QuotaRule struct {
+	Limit         int   `json:"limit"`
+	WindowSeconds int64 `json:"windowSeconds"`
+}
+
+// Quota usage schema is written by consumeQuota for each owner and limited function, write and cas count against the caller.
+// To store this data the key will be: "Quota_" + ownerId + "_" + function
+type QuotaUsage struct {
+	OperationType string  `json:"operationType"` //always Quota
+	Owner         string  `json:"owner"`
+	Function      string  `json:"function"`
+	Calls         []int64 `json:"calls"` //tx timestamps in seconds still inside windowSeconds of the QuotaRule
+}
+
+const chaincodeConfigKey = "ChaincodeConfig"
+
+// ownerIds are the hex string of the md5 hash of the creator, see getCallerId, so their length is not configurable.
+const ownerIdLength = 32
+
+// quotaFunctions and switchableFunctions are the functions the config can limit or turn off, the config functions themselves
+// can never be turned off.
+var quotaFunctions = []string{"DataRegister", "OnBoarding", "write", "cas"}
+
+var switchableFunctions = []string{"write", "read", "cas", "readRecord", "setACL", "readRange", "listPrefix", "listPath", "Query", "OrgRegister", "DataRegister", "OnBoarding", "WhoAmI"}
+
+// defaultChaincodeConfig is written by Init when no config is given, and fills the fields a config document leaves out.
+func defaultChaincodeConfig(adminOrg string) ChaincodeConfig {
+	adminOrgs := []string{}
+	if len(adminOrg) > 0 {
+		adminOrgs = append(adminOrgs, adminOrg)
+	}
+	return ChaincodeConfig{0, adminOrgs, map[string]QuotaRule{}, []string{}, "", map[string]bool{}, "", pb_timestamp.Timestamp{}}
+}
+
+// ========================================================
+// initChaincodeConfig is called by Init with the optional config document. Without a document an existing config is kept,
+// so an upgrade does not reset it, and a new instance gets the default config administered by the MSP of the instantiator.
+// An upgrade can not replace an existing config with a document, that is left to the admin orgs through UpdateConfig.
+// ========================================================
+func initChaincodeConfig(stub shim.ChaincodeStubInterface, document string) error {
+	mspId, err := getMspId(stub)
+	if err != nil {
+		return err
+	}
+	existing, err := stub.GetState(chaincodeConfigKey)
+	if err != nil {
+		return err
+	}
+	if len(document) == 0 {
+		if existing != nil {
+			return nil
+		}
+		return putChaincodeConfig(stub, defaultChaincodeConfig(mspId), mspId)
+	}
+	if existing != nil {
+		return errors.New("The chaincode already has a config, upgrade without the config document and change it with UpdateConfig.")
+	}
+
+	config, err := parseChaincodeConfig(document)
+	if err != nil {
+		return err
+	}
+	return putChaincodeConfig(stub, config, mspId)
+}
+
+// ========================================================
+// loadChaincodeConfig reads the config written by Init, falls back to the default if Init never wrote one.
+// Invoke loads it once and hands it to the functions, so every endorser decides on the config of the ledger it reads.
+// ========================================================
+func loadChaincodeConfig(stub shim.ChaincodeStubInterface) (ChaincodeConfig, error) {
+	config := defaultChaincodeConfig("")
+	configJSONasBytes, err := stub.GetState(chaincodeConfigKey)
+	if err != nil {
+		return config, err
+	}
+	if configJSONasBytes != nil {
+		err = json.Unmarshal(configJSONasBytes, &config)
+	}
+	return config, err
+}
+
+// ========================================================
+// parseChaincodeConfig unmarshals and validates a config document, the fields left out get the default values.
+// ========================================================
+func parseChaincodeConfig(document string) (ChaincodeConfig, error) {
+	config := defaultChaincodeConfig("")
+	decoder := json.NewDecoder(strings.NewReader(document))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&config)
+	if err != nil {
+		return config, errors.New(fmt.Sprintf("The config document must be a JSON object of ChaincodeConfig, err:%s", err))
+	}
+
+	if len(config.AdminOrgs) == 0 {
+		return config, errors.New("The config must have at least one admin org in adminOrgs.")
+	}
+	for _, adminOrg := range config.AdminOrgs {
+		if len(adminOrg) == 0 {
+			return config, errors.New("The admin orgs must be non-empty MSP ids.")
+		}
+	}
+	for function, rule := range config.Quotas {
+		if !containsString(quotaFunctions, function) {
+			return config, errors.New(fmt.Sprintf("The function:%s can not have a quota, expecting one of %s.", function, strings.Join(quotaFunctions, ", ")))
+		}
+		if rule.Limit < 0 || rule.WindowSeconds < 1 {
+			return config, errors.New(fmt.Sprintf("The quota of function:%s must have a non-negative limit and a positive windowSeconds.", function))
+		}
+	}
+	for i, dataType := range config.AllowedDataTypes {
+		if len(dataType) == 0 {
+			return config, errors.New("The allowed dataTypes must be non-empty strings.")
+		}
+		config.AllowedDataTypes[i] = strings.ToLower(dataType)
+	}
+	if len(config.DataExpiry) > 0 {
+		expiry, err := time.ParseDuration(config.DataExpiry)
+		if err != nil || expiry <= 0 {
+			return config, errors.New(fmt.Sprintf("The dataExpiry:%s must be a positive duration like 720h.", config.DataExpiry))
+		}
+	}
+	for function := range config.Features {
+		if !containsString(switchableFunctions, function) {
+			return config, errors.New(fmt.Sprintf("The function:%s can not be switched, expecting one of %s.", function, strings.Join(switchableFunctions, ", ")))
+		}
+	}
+	return config, nil
+}
+
+func putChaincodeConfig(stub shim.ChaincodeStubInterface, config ChaincodeConfig, updatedBy string) error {
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	config.UpdatedBy = updatedBy
+	config.Timestamp = txTimestamp
+	configJSONasBytes, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", chaincodeConfigKey, redactRecord(configJSONasBytes))
+	return stub.PutState(chaincodeConfigKey, configJSONasBytes)
+}
+
+// ============================================================================================================================
+// UpdateConfig - replace the config document, only the admin orgs can do it. The expectedVersion guards against
+// overwriting a change made by another admin in the meantime.
+// ============================================================================================================================
+func (t *SimpleChaincode) UpdateConfig(stub shim.ChaincodeStubInterface, current ChaincodeConfig) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	//-------------2 parameters------------
+	//     0           1
+	//   "Config"  "ExpectedVersion"
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 parameters for UpdateConfig")
+	}
+	mspId, err := getMspId(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !containsString(current.AdminOrgs, mspId) {
+		newTxLogger(stub).Warningf("UpdateConfig refused for org:%s", mspId)
+		return shim.Error(fmt.Sprintf("The org:%s is not an admin org, only %s can update the config.", mspId, strings.Join(current.AdminOrgs, ", ")))
+	}
+	if args[1] != fmt.Sprintf("%d", current.Version) {
+		return shim.Error(fmt.Sprintf("Config version conflict, expected version:%s, current version:%d.", args[1], current.Version))
+	}
+
+	config, err := parseChaincodeConfig(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	config.Version = current.Version + 1
+	err = putChaincodeConfig(stub, config, mspId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	newTxLogger(stub).Infof("UpdateConfig applied, version:%d", config.Version)
+	return shim.Success([]byte(fmt.Sprintf("%d", config.Version)))
+}
+
+// ============================================================================================================================
+// GetConfig - query the config document.
+// ============================================================================================================================
+func (t *SimpleChaincode) GetConfig(stub shim.ChaincodeStubInterface, config ChaincodeConfig) pb.Response {
+	configJSONasBytes, err := json.Marshal(config)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(configJSONasBytes)
+}
+
+// ========================================================
+// isFunctionEnabled checks the feature switches, the functions not listed are enabled.
+// ========================================================
+func isFunctionEnabled(config ChaincodeConfig, function string) bool {
+	enabled, ok := config.Features[function]
+	return !ok || enabled
+}
+
+// ========================================================
+// checkOwnerId checks the length of an ownerId.
+// ========================================================
+func checkOwnerId(field string, ownerId string) error {
+	if len(ownerId) != ownerIdLength {
+		return errors.New(fmt.Sprintf("Incorrect %s. Expecting 16 bytes of md5 hash which has len == %d of hex string. %s:%s", field, ownerIdLength, field, ownerId))
+	}
+	return nil
+}
+
+// ========================================================
+// checkDataType checks a dataType against the allowed dataTypes of the config.
+// ========================================================
+func checkDataType(config ChaincodeConfig, dataType string) error {
+	allowed := config.AllowedDataTypes
+	if len(allowed) > 0 && !containsString(allowed, dataType) {
+		return errors.New(fmt.Sprintf("The dataType:%s is not allowed, expecting one of %s.", dataType, strings.Join(allowed, ", ")))
+	}
+	return nil
+}
+
+// ========================================================
+// defaultDataExpiry returns the expiry of data registered now, 0 means never expires.
+// ========================================================
+func defaultDataExpiry(config ChaincodeConfig, txTimestamp pb_timestamp.Timestamp) pb_timestamp.Timestamp {
+	dataExpiry := config.DataExpiry
+	if len(dataExpiry) == 0 {
+		return pb_timestamp.Timestamp{0, 0}
+	}
+	expiry, err := time.ParseDuration(dataExpiry)
+	if err != nil {
+		return pb_timestamp.Timestamp{0, 0}
+	}
+	return pb_timestamp.Timestamp{txTimestamp.Seconds + int64(expiry/time.Second), txTimestamp.Nanos}
+}
+
+// ========================================================
+// isDataExpired checks the expiryTimestamp of a DataRegister record, 0 means never expires.
+// ========================================================
+func isDataExpired(record DataRegistering, txTimestamp pb_timestamp.Timestamp) bool {
+	if record.ExpiryTimestamp.Seconds == 0 && record.ExpiryTimestamp.Nanos == 0 {
+		return false
+	}
+	return record.ExpiryTimestamp.Seconds < txTimestamp.Seconds ||
+		(record.ExpiryTimestamp.Seconds == txTimestamp.Seconds && record.ExpiryTimestamp.Nanos <= txTimestamp.Nanos)
+}
+
+// ========================================================
+// consumeQuota records a call of the function by the owner, and fails if the quota of the config is used up.
+// ========================================================
+func consumeQuota(stub shim.ChaincodeStubInterface, config ChaincodeConfig, ownerId string, function string) error {
+	rule, ok := config.Quotas[function]
+	if !ok {
+		return nil
+	}
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return err
+	}
+
+	key := "Quota_" + ownerId + "_" + function
+	usageJSONasBytes, err := stub.GetState(key)
+	if err != nil {
+		return err
+	}
+	usage := QuotaUsage{"Quota", ownerId, function, []int64{}}
+	if usageJSONasBytes != nil {
+		err = json.Unmarshal(usageJSONasBytes, &usage)
+		if err != nil {
+			return err
+		}
+	}
+	calls := []int64{}
+	for _, call := range usage.Calls {
+		if call > txTimestamp.Seconds-rule.WindowSeconds {
+			calls = append(calls, call)
+		}
+	}
+	if len(calls) >= rule.Limit {
+		return errors.New(fmt.Sprintf("The quota of %s is used up, %d calls in %d seconds are allowed.", function, rule.Limit, rule.WindowSeconds))
+	}
+	usage.Calls = append(calls, txTimestamp.Seconds)
+
+	usageJSONasBytes, err = json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(key), redactRecord(usageJSONasBytes))
+	return stub.PutState(key, usageJSONasBytes)
+}
+
+// ========================================================
+// getMspId returns the MSP id of the caller
+// ========================================================
+func getMspId(stub shim.ChaincodeStubInterface) (string, error) {
+	creator, err := stub.GetCreator()
+	if err != nil {
+		return "", err
+	}
+	serializedIdentity := &pb_msp.SerializedIdentity{}
+	err = proto.Unmarshal(creator, serializedIdentity)
+	if err != nil {
+		return "", err
+	}
+	return serializedIdentity.Mspid, nil
+}
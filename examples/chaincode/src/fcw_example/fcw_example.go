@@ -46,6 +46,7 @@ type DataRegistering struct {
 	Timestamp   	pb_timestamp.Timestamp   `json:"timestamp"` //the time when the action happens
 	MatchCount		int		`json:"matchCount"`		//how many times the data has ever been matched before.
 	LastMatchTimestamp	pb_timestamp.Timestamp   `json:"lastMatchTimestamp"` //the time when the data participated matching before.
+	ExpiryTimestamp	pb_timestamp.Timestamp   `json:"expiryTimestamp"` //the data can not be matched after this time, 0 means never expires. Set from dataExpiry of ChaincodeConfig.
 }
 
 // On boarding schema is used for matching.
@@ -77,28 +78,56 @@ type QueryResult_DataRegistering_Array []*QueryResult_DataRegistering
 // ============================================================================================================================
 func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
-	fmt.Println(" ")
-	fmt.Println("starting invoke, for - " + function)
+	err := loadLoggingConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	config, err := loadChaincodeConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	newTxLogger(stub).Infof("starting invoke, for - %s", function)
+
+	if !isFunctionEnabled(config, function) {
+		newTxLogger(stub).Warningf("Function %s is switched off by the config", function)
+		return shim.Error("Function '" + function + "' is switched off by the config")
+	}
 
 	// Handle different functions
 	if function == "write" {           //generic writes to ledger
-		return t.write(stub, args)
+		return t.write(stub, args, config)
 	} else if function == "read" {            //generic read ledger
 		return t.read(stub, args)
+	} else if function == "cas" {             //generic compare and swap write with version
+		return t.cas(stub, args, config)
+	} else if function == "readRecord" {      //generic read with version, writer and ACL
+		return t.readRecord(stub, args)
+	} else if function == "setACL" {          //let other owners write a generic variable
+		return t.setACL(stub, args)
+	} else if function == "readRange" {       //generic read of the variables in a range of names
+		return t.readRange(stub, args)
+	} else if function == "listPrefix" {      //generic read of the variables with a name prefix
+		return t.listPrefix(stub, args)
+	} else if function == "listPath" {        //generic read of the variables under a "/" separated path
+		return t.listPath(stub, args)
 	} else if function == "Query" {           //query ledger with complex JSON query string
         return t.Query(stub)
     } else if function == "OrgRegister" {
 		return t.OrgRegister(stub)
 	} else if function == "DataRegister" {
-		return t.DataRegister(stub)
+		return t.DataRegister(stub, config)
 	} else if function == "OnBoarding" {
-		return t.OnBoarding(stub)
+		return t.OnBoarding(stub, config)
 	} else if function == "WhoAmI" {
 		return t.WhoAmI(stub)
+	} else if function == "UpdateConfig" {
+		return t.UpdateConfig(stub, config)
+	} else if function == "GetConfig" {
+		return t.GetConfig(stub, config)
 	}
 
 	// error out
-	fmt.Println("Received unknown invoke function name - " + function)
+	newTxLogger(stub).Warningf("Received unknown invoke function name - %s", function)
 	return shim.Error("Received unknown invoke function name - '" + function + "'")
 }
 
@@ -107,26 +136,36 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 // ============================================================================================================================
 func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	_, args := stub.GetFunctionAndParameters()
-	var Aval int
 	var err error
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+	//     0(optional)       1(optional)       2(optional)
+	//  "Config"        "LogLevel"    "RedactSensitive"
+	// Config is the JSON document of ChaincodeConfig. An integer is still accepted in its place and ignored,
+	// so the old instantiate commands keep working.
+	if len(args) > 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 0 to 3")
 	}
-
-	// Initialize the chaincode
-	Aval, err = strconv.Atoi(args[0])
+	var loggingArgs []string
+	if len(args) > 1 {
+		loggingArgs = args[1:]
+	}
+	err = initLoggingConfig(stub, loggingArgs)
 	if err != nil {
-		return shim.Error("Expecting integer value for asset holding")
+		return shim.Error(err.Error())
 	}
 
-	// Write the state to the ledger
-	err = stub.PutState("abc", []byte(strconv.Itoa(Aval))) //making a test var "abc", I find it handy to read/write to it right away to test the network
+	var document string
+	if len(args) > 0 {
+		if _, err = strconv.Atoi(args[0]); err != nil {
+			document = args[0]
+		}
+	}
+	err = initChaincodeConfig(stub, document)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	fmt.Println(" - ready for action")
+	newTxLogger(stub).Infof(" - ready for action")
 	return shim.Success(nil)
 }
 
@@ -160,7 +199,7 @@ func (t *SimpleChaincode) OrgRegister(stub shim.ChaincodeStubInterface) pb.Respo
 		return shim.Error(err.Error())
 	}
 	if bytes.Equal(queryResults[:], emptyQueryResults[:]) == false {
-		fmt.Printf("Already did OrgRegister:%s\n", queryResults)
+		newTxLogger(stub).Infof("Already did OrgRegister:%s", redactRecord(queryResults))
 		return shim.Success(nil)
 	}
 
@@ -177,7 +216,7 @@ func (t *SimpleChaincode) OrgRegister(stub shim.ChaincodeStubInterface) pb.Respo
 
 	// === Save org to state ===
 	key := operationType + "_" + ownerId
-	fmt.Printf("Starting PutState, key:%s, value:%s\n", key, string(dataJSONasBytes))
+	newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(key), redactRecord(dataJSONasBytes))
 	err = stub.PutState(key, dataJSONasBytes)
 	if err != nil {
 		return shim.Error(err.Error())
@@ -190,7 +229,7 @@ func (t *SimpleChaincode) OrgRegister(stub shim.ChaincodeStubInterface) pb.Respo
 // DataRegister will only happen when the peer first time try to start a transaction(like uploading new file)
 // If the DataRegister is already done before, nothing will be happen here.
 // ============================================================================================================================
-func (t *SimpleChaincode) DataRegister(stub shim.ChaincodeStubInterface) pb.Response {
+func (t *SimpleChaincode) DataRegister(stub shim.ChaincodeStubInterface, config ChaincodeConfig) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 	//-------------3 parameters------------
 	//     0       		1       	2		3		4
@@ -223,6 +262,10 @@ func (t *SimpleChaincode) DataRegister(stub shim.ChaincodeStubInterface) pb.Resp
 
 	dataType := strings.ToLower(args[0])
 	dataName := strings.ToLower(args[1])
+	err = checkDataType(config, dataType)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	lineCount, err := strconv.Atoi(args[2])
 	if err != nil {
@@ -238,9 +281,13 @@ func (t *SimpleChaincode) DataRegister(stub shim.ChaincodeStubInterface) pb.Resp
 		return shim.Error(err.Error())
 	}
 	if bytes.Equal(queryResults[:], emptyQueryResults[:]) == false {
-		fmt.Printf("Already did DataRegister:%s\n", queryResults)
+		newTxLogger(stub).Infof("Already did DataRegister:%s", redactRecord(queryResults))
 		return shim.Success(nil)
 	}
+	err = consumeQuota(stub, config, ownerId, operationType)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	// === prepare the org json ===
 	txTimestamp, err := getTxTimestamp(stub)
@@ -257,7 +304,8 @@ func (t *SimpleChaincode) DataRegister(stub shim.ChaincodeStubInterface) pb.Resp
 		bloom,
 		txTimestamp,
 		0,
-		pb_timestamp.Timestamp{0,0}} // lastMatchTimestamp is 0 when registering.
+		pb_timestamp.Timestamp{0,0}, // lastMatchTimestamp is 0 when registering.
+		defaultDataExpiry(config, txTimestamp)}
 
 	dataJSONasBytes, err := json.Marshal(data)
 	if err != nil {
@@ -266,7 +314,7 @@ func (t *SimpleChaincode) DataRegister(stub shim.ChaincodeStubInterface) pb.Resp
 
 	// === Save data to state ===
 	key := operationType + "_" + ownerId + "_" + dataName
-	fmt.Printf("Starting PutState, key:%s, value:%s\n", key, string(dataJSONasBytes))
+	newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(key), redactRecord(dataJSONasBytes))
 	err = stub.PutState(key, dataJSONasBytes)
 	if err != nil {
 		return shim.Error(err.Error())
@@ -278,7 +326,7 @@ func (t *SimpleChaincode) DataRegister(stub shim.ChaincodeStubInterface) pb.Resp
 // OnBoarding is the main function used to start matching data between owner and targetOwner.
 // The step starts from '1', and SDK clients will listen on event whether targetOwner is the same as theirs, if 'Yes' starts OnBoarding
 // =====================================================================================================================================
-func (t *SimpleChaincode) OnBoarding(stub shim.ChaincodeStubInterface) pb.Response {
+func (t *SimpleChaincode) OnBoarding(stub shim.ChaincodeStubInterface, config ChaincodeConfig) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 	//---------------------------------------7 parameters-------------------------------------------------
 	//     0       	 1       		2     		  		3  			   	  4			 	  5			  6				7
@@ -336,7 +384,7 @@ func (t *SimpleChaincode) OnBoarding(stub shim.ChaincodeStubInterface) pb.Respon
 			return shim.Error(err.Error())
 		}
 		if bytes.Equal(queryResults[:], emptyQueryResults[:]) {
-			fmt.Sprintf("Current owner:%s has not registered yet, please do OrgRegister first.\n", ownerId)
+			newTxLogger(stub).Warningf("Current owner:%s has not registered yet, please do OrgRegister first.", redactOwner(ownerId))
 			return shim.Error(fmt.Sprintf("Current owner:%s has not registered yet, please do OrgRegister first.", ownerId))
 		}
 
@@ -345,7 +393,7 @@ func (t *SimpleChaincode) OnBoarding(stub shim.ChaincodeStubInterface) pb.Respon
 			return shim.Error(err.Error())
 		}
 		if bytes.Equal(queryResults[:], emptyQueryResults[:]) {
-			fmt.Sprintf("targetOwner:%s has not registered yet, please do OrgRegister first.\n", targetOwner)
+			newTxLogger(stub).Warningf("targetOwner:%s has not registered yet, please do OrgRegister first.", redactOwner(targetOwner))
 			return shim.Error(fmt.Sprintf("targetOwner:%s has not registered yet, please do OrgRegister first.", targetOwner))
 		}
 
@@ -355,7 +403,7 @@ func (t *SimpleChaincode) OnBoarding(stub shim.ChaincodeStubInterface) pb.Respon
 			return shim.Error(err.Error())
 		}
 		if bytes.Equal(queryResults[:], emptyQueryResults[:]) {
-			fmt.Sprintf("Current owner:%s doesn't have data:%s yet, please do DataRegister for this data first.\n", ownerId, dataName)
+			newTxLogger(stub).Warningf("Current owner:%s doesn't have data:%s yet, please do DataRegister for this data first.", redactOwner(ownerId), dataName)
 			return shim.Error(fmt.Sprintf("Current owner:%s doesn't have data:%s yet, please do DataRegister for this data first.", ownerId, dataName))
 		}
 
@@ -365,7 +413,7 @@ func (t *SimpleChaincode) OnBoarding(stub shim.ChaincodeStubInterface) pb.Respon
 		}
 
 		if bytes.Equal(queryResults[:], emptyQueryResults[:]) {
-			fmt.Sprintf("The targetOwner:%s doesn't have data:%s yet, please double check.\n", targetOwner, targetDataName)
+			newTxLogger(stub).Warningf("The targetOwner:%s doesn't have data:%s yet, please double check.", redactOwner(targetOwner), targetDataName)
 			return shim.Error(fmt.Sprintf("The targetOwner:%s doesn't have data:%s yet, please double check.", targetOwner, targetDataName))
 		}
 
@@ -384,6 +432,20 @@ func (t *SimpleChaincode) OnBoarding(stub shim.ChaincodeStubInterface) pb.Respon
 			}
 			return shim.Error(fmt.Sprintf("This OnBoarding action already finished before, txID:%s", dataJSON.TxID))
 		}
+
+		//for step 1, the data of both sides must not be expired
+		err = checkDataNotExpired(stub, ownerId, dataName)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = checkDataNotExpired(stub, targetOwner, targetDataName)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = consumeQuota(stub, config, ownerId, operationType)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 	} else {
 		//here means step > 1
 		//check step, whether there is a (step - 1) happened before to make sure this is correct step. Also the step should not finished(isFinished==false)
@@ -431,7 +493,7 @@ func (t *SimpleChaincode) OnBoarding(stub shim.ChaincodeStubInterface) pb.Respon
 
 	// === Save matching step to state ===
 	key := operationType + "_" + txID
-	fmt.Printf("Starting PutState, key:%s, value:%s\n", key, string(dataJSONasBytes))
+	newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(key), redactRecord(dataJSONasBytes))
 	err = stub.PutState(key, dataJSONasBytes)
 	if err != nil {
 		return shim.Error(err.Error())
@@ -465,7 +527,7 @@ func (t *SimpleChaincode) OnBoarding(stub shim.ChaincodeStubInterface) pb.Respon
 		if err != nil {
 			return shim.Error(err.Error())
 		}
-		fmt.Printf("Starting PutState, key:%s, value:%s\n", key, string(dataJSONasBytes))
+		newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(key), redactRecord(dataJSONasBytes))
 		err = stub.PutState(key, dataJSONasBytes)
 		if err != nil {
 			return shim.Error(err.Error())
@@ -475,52 +537,6 @@ func (t *SimpleChaincode) OnBoarding(stub shim.ChaincodeStubInterface) pb.Respon
 	return shim.Success(nil)
 }
 
-// ============================================================================================================================
-// Write - genric write variable into ledger
-// ============================================================================================================================
-func (t *SimpleChaincode) write(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var name, value string                           // Entities
-	var err error
-	fmt.Println("starting write")
-
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2. name of the variable and value to set")
-	}
-
-	name = args[0]                                   //rename for funsies
-	value = args[1]
-	err = stub.PutState(name, []byte(value))         //write the variable into the ledger
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-
-	fmt.Println("- end write")
-	return shim.Success(nil)
-}
-
-// ============================================================================================================================
-// Read - read a generic variable from ledger
-// ============================================================================================================================
-func (t *SimpleChaincode) read(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var name, jsonResp string
-	var err error
-	fmt.Println("starting read")
-
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting name of the var to query")
-	}
-
-	name = args[0]
-	valAsbytes, err := stub.GetState(name)           //get the var from ledger
-	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + name + "\"}"
-		return shim.Error(jsonResp)
-	}
-
-	fmt.Println("- end read")
-	return shim.Success(valAsbytes)                  //send it onward
-}
-
 // ============================================================================================================================
 // Query - query who am I, if I already registered, return the registered record, otherwise return nil.
 // ============================================================================================================================
@@ -556,7 +572,7 @@ func (t *SimpleChaincode) WhoAmI(stub shim.ChaincodeStubInterface) pb.Response {
 func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface) pb.Response {
 	_, args := stub.GetFunctionAndParameters()
 	var err error
-	fmt.Println("starting Query")
+	newTxLogger(stub).Debugf("starting Query")
 
 	if len(args) != 1 {
 		return shim.Error("Incorrect number of arguments. Expecting query string of JSON to query")
@@ -575,13 +591,13 @@ func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface) pb.Response {
 // ============================================================================================================================
 func queryByOwnerAndOperationType(stub shim.ChaincodeStubInterface, operationType string, ownerId string) ([]byte, error) {
 	var err error
-	fmt.Println("starting queryByOwnerAndOperationType")
+	newTxLogger(stub).Debugf("starting queryByOwnerAndOperationType")
 
 	if len(operationType) == 0 {
 		return nil, errors.New("Incorrect operationType. Expecting non empty type.")
 	}
-	if len(ownerId) != 32 {
-		return nil, errors.New(fmt.Sprintf("Incorrect ownerId. Expecting 16 bytes of md5 hash which has len == 32 of hex string. ownerId:%s", ownerId))
+	if err = checkOwnerId("ownerId", ownerId); err != nil {
+		return nil, err
 	}
 
 	queryString := fmt.Sprintf("{\"selector\":{\"operationType\":\"%s\",\"owner\":\"%s\"}}", operationType, ownerId)
@@ -597,13 +613,13 @@ func queryByOwnerAndOperationType(stub shim.ChaincodeStubInterface, operationTyp
 // ============================================================================================================================
 func queryByDataAndOperationType(stub shim.ChaincodeStubInterface, operationType string, ownerId string, dataName string) ([]byte, error) {
 	var err error
-	fmt.Println("starting queryByDataAndOperationType")
+	newTxLogger(stub).Debugf("starting queryByDataAndOperationType")
 
 	if len(operationType) == 0 {
 		return nil, errors.New("Incorrect operationType. Expecting non empty type.")
 	}
-	if len(ownerId) != 32 {
-		return nil, errors.New(fmt.Sprintf("Incorrect ownerId. Expecting 16 bytes of md5 hash which has len == 32 of hex string. ownerId:%s", ownerId))
+	if err = checkOwnerId("ownerId", ownerId); err != nil {
+		return nil, err
 	}
 	if len(dataName) == 0 {
 		return nil, errors.New("Incorrect dataName. Expecting non empty dataName.")
@@ -630,7 +646,7 @@ func queryByStepAndOperationType(stub shim.ChaincodeStubInterface,
                                  targetOwner string,
                                  targetDataName string) ([]byte, error) {
 	var err error
-	fmt.Println("starting queryByDataAndOperationType")
+	newTxLogger(stub).Debugf("starting queryByDataAndOperationType")
 
 	if len(operationType) == 0 {
 		return nil, errors.New("Incorrect operationType. Expecting non empty type.")
@@ -638,8 +654,11 @@ func queryByStepAndOperationType(stub shim.ChaincodeStubInterface,
 	if byStep && step < 1 {
 		return nil, errors.New("Incorrect step. Expecting step >= 1.")
 	}
-	if len(ownerId) != 32 || len(targetOwner) != 32 {
-		return nil, errors.New(fmt.Sprintf("Incorrect owner or targetOwner. Expecting 16 bytes of md5 hash which has len == 32 of hex string.ownerId:%s", ownerId))
+	if err = checkOwnerId("ownerId", ownerId); err != nil {
+		return nil, err
+	}
+	if err = checkOwnerId("targetOwner", targetOwner); err != nil {
+		return nil, err
 	}
 	if len(dataName) == 0 || len(targetDataName) == 0 {
 		return nil, errors.New("Incorrect dataName or targetDataName. Expecting non empty dataName and targetDataName.")
@@ -654,7 +673,7 @@ func queryByStepAndOperationType(stub shim.ChaincodeStubInterface,
 			operationType, ownerId, dataName, targetOwner, targetDataName)
 	}
 
-	fmt.Printf("- queryByStepAndOperationType queryString:\n%s\n", queryString)
+	newTxLogger(stub).Debugf("- queryByStepAndOperationType queryString:%s", redactRecord([]byte(queryString)))
 
 	resultsIterator, err := stub.GetQueryResult(queryString)
 	if err != nil {
@@ -669,17 +688,43 @@ func queryByStepAndOperationType(stub shim.ChaincodeStubInterface,
 		return nil, err
 	}
 
-	fmt.Printf("- queryByStepAndOperationType queryResult:\n%s\n", queryResponse.Value)
+	newTxLogger(stub).Debugf("- queryByStepAndOperationType queryResult:%s", redactRecord(queryResponse.Value))
 	return queryResponse.Value, nil
 }
 
+// ============================================================================================================================
+// checkDataNotExpired - the registered data of the owner must exist and not be expired.
+// ============================================================================================================================
+func checkDataNotExpired(stub shim.ChaincodeStubInterface, ownerId string, dataName string) error {
+	queryResults, err := queryByDataAndOperationType(stub, "DataRegister", ownerId, dataName)
+	if err != nil {
+		return err
+	}
+	var queryResult_DataRegistering_Array QueryResult_DataRegistering_Array
+	err = json.Unmarshal(queryResults, &queryResult_DataRegistering_Array)
+	if err != nil {
+		return err
+	}
+	if len(queryResult_DataRegistering_Array) == 0 {
+		return errors.New(fmt.Sprintf("The dataName:%s belongs to owner:%s doesn't exist.", dataName, ownerId))
+	}
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	if isDataExpired(queryResult_DataRegistering_Array[0].Record, txTimestamp) {
+		return errors.New(fmt.Sprintf("The dataName:%s belongs to owner:%s is expired.", dataName, ownerId))
+	}
+	return nil
+}
+
 // =========================================================================================
 // getQueryResultForQueryString executes the passed in query string.
 // Result set is built and returned as a byte array containing the JSON results.
 // =========================================================================================
 func getQueryResultForQueryString(stub shim.ChaincodeStubInterface, queryString string) ([]byte, error) {
 
-	fmt.Printf("- getQueryResultForQueryString queryString:\n%s\n", queryString)
+	newTxLogger(stub).Debugf("- getQueryResultForQueryString queryString:%s", redactRecord([]byte(queryString)))
 
 	resultsIterator, err := stub.GetQueryResult(queryString)
 	if err != nil {
@@ -714,7 +759,7 @@ func getQueryResultForQueryString(stub shim.ChaincodeStubInterface, queryString
 	}
 	buffer.WriteString("]")
 
-	fmt.Printf("- getQueryResultForQueryString queryResult:\n%s\n", buffer.String())
+	newTxLogger(stub).Debugf("- getQueryResultForQueryString queryResult:%s", redactRecord(buffer.Bytes()))
 	return buffer.Bytes(), nil
 }
 
@@ -744,7 +789,6 @@ func md5_hash(idBytes []byte) (string, error) {
 	digest := md5.New()
 	digest.Write(idBytes)
 	hash_cert := digest.Sum(nil)
-	fmt.Printf("MD5 Hash of idBytes Hex:%x\n", hash_cert) // 16 bytes
 	return fmt.Sprintf("%x", hash_cert), nil
 }
 
@@ -755,14 +799,14 @@ func md5_hash(idBytes []byte) (string, error) {
 func getCert(stub shim.ChaincodeStubInterface) ([]byte, error) {
 	creator, err := stub.GetCreator()
 	if err != nil {
-		fmt.Errorf("Failed to get creator info")
+		newTxLogger(stub).Errorf("Failed to get creator info, err:%s", err)
 		return nil, err
 	}
 
 	serializedIdentity := &pb_msp.SerializedIdentity{}
 	err = proto.Unmarshal(creator, serializedIdentity)
 	if err != nil {
-		fmt.Sprintf("Failed to Unmarshal serializedIdentity, err %s", err)
+		newTxLogger(stub).Errorf("Failed to Unmarshal serializedIdentity, err:%s", err)
 		return nil, err
 	}
 	return serializedIdentity.IdBytes, nil
@@ -771,7 +815,7 @@ func getCert(stub shim.ChaincodeStubInterface) ([]byte, error) {
 func getTxTimestamp(stub shim.ChaincodeStubInterface) (pb_timestamp.Timestamp, error) {
 	pTxTimestamp, err := stub.GetTxTimestamp()
 	if err != nil {
-		fmt.Errorf("Failed to call stub.GetTxTimestamp, err:%s\n",err.Error())
+		newTxLogger(stub).Errorf("Failed to call stub.GetTxTimestamp, err:%s", err)
 		return pb_timestamp.Timestamp{}, err
 	}
 	txTimestamp := pb_timestamp.Timestamp{}
@@ -816,6 +860,6 @@ func getOrgNameAndCommonName(idBytes []byte) (string, string, error) {
 func main() {
 	err := shim.Start(new(SimpleChaincode))
 	if err != nil {
-		fmt.Printf("Error starting Simple chaincode - %s", err)
+		logger.Criticalf("Error starting Simple chaincode - %s", err)
 	}
 }
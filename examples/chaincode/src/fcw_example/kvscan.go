@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Key value page is returned by readRange, listPrefix and listPath, pass Bookmark to the next call until it is empty.
+type KeyValuePage struct {
+	Owner    string     `json:"owner"`
+	Records  []KeyValue `json:"records"`
+	Bookmark string     `json:"bookmark,omitempty"` //name of the first variable of the next page
+}
+
+// The path index lists the variables by the "/" separated segments of their names, it is written with every write.
+// To store this data the key will be the composite key: "KVPath" + ownerId + segments of the name
+const kvPathObjectType = "KVPath"
+
+const defaultScanPageSize = 50
+const maxScanPageSize = 500
+
+// ============================================================================================================================
+// readRange - read the variables with startName <= name < endName from a namespace, ordered by name. An empty endName reads
+// to the end of the namespace. Range scans work on LevelDB as well as CouchDB.
+// ============================================================================================================================
+func (t *SimpleChaincode) readRange(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//-------------5 parameters------------
+	//     0             1           2(optional)    3(optional)    4(optional)
+	//   "StartName"  "EndName"   "PageSize"     "Bookmark"     "OwnerId": namespace to read, the caller's by default
+
+	if len(args) < 2 || len(args) > 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 to 5 parameters for readRange")
+	}
+	pageSize, bookmark, ownerId, err := parseScanArguments(stub, args[2:])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	startName := args[0]
+	if len(bookmark) > 0 {
+		if bookmark < startName || (len(args[1]) > 0 && bookmark >= args[1]) {
+			return shim.Error(fmt.Sprintf("The bookmark:%s is out of the range.", bookmark))
+		}
+		startName = bookmark
+	}
+	namespace := kvOperationType + "_" + ownerId + "_"
+	endKey := namespace + args[1]
+	if len(args[1]) == 0 {
+		endKey = namespace + string(utf8.MaxRune)
+	}
+
+	page, err := scanKeyValues(stub, ownerId, namespace+startName, endKey, pageSize)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(page)
+}
+
+// ============================================================================================================================
+// listPrefix - read the variables whose names start with the prefix from a namespace, ordered by name.
+// ============================================================================================================================
+func (t *SimpleChaincode) listPrefix(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//-------------4 parameters------------
+	//     0          1(optional)    2(optional)    3(optional)
+	//   "Prefix"  "PageSize"     "Bookmark"     "OwnerId": namespace to read, the caller's by default
+
+	if len(args) < 1 || len(args) > 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 to 4 parameters for listPrefix")
+	}
+	pageSize, bookmark, ownerId, err := parseScanArguments(stub, args[1:])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	startName := args[0]
+	if len(bookmark) > 0 {
+		if !strings.HasPrefix(bookmark, args[0]) {
+			return shim.Error(fmt.Sprintf("The bookmark:%s doesn't have the prefix:%s.", bookmark, args[0]))
+		}
+		startName = bookmark
+	}
+	namespace := kvOperationType + "_" + ownerId + "_"
+
+	page, err := scanKeyValues(stub, ownerId, namespace+startName, namespace+args[0]+string(utf8.MaxRune), pageSize)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(page)
+}
+
+// ============================================================================================================================
+// listPath - read the variables under a path through the composite key index, the names are split by "/". Unlike listPrefix,
+// the path "a/b" lists "a/b" and "a/b/c" but not "a/bc". An empty path lists the whole namespace.
+// ============================================================================================================================
+func (t *SimpleChaincode) listPath(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//-------------4 parameters------------
+	//     0        1(optional)    2(optional)    3(optional)
+	//   "Path"  "PageSize"     "Bookmark"     "OwnerId": namespace to read, the caller's by default
+
+	if len(args) < 1 || len(args) > 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 to 4 parameters for listPath")
+	}
+	pageSize, bookmark, ownerId, err := parseScanArguments(stub, args[1:])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pathAttributes := []string{ownerId}
+	if len(args[0]) > 0 {
+		pathAttributes = append(pathAttributes, strings.Split(args[0], "/")...)
+	}
+	pathKey, err := stub.CreateCompositeKey(kvPathObjectType, pathAttributes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	startKey := pathKey
+	if len(bookmark) > 0 {
+		startKey, err = stub.CreateCompositeKey(kvPathObjectType, append([]string{ownerId}, strings.Split(bookmark, "/")...))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !strings.HasPrefix(startKey, pathKey) {
+			return shim.Error(fmt.Sprintf("The bookmark:%s is not under the path:%s.", bookmark, args[0]))
+		}
+	}
+
+	// range scans do not take composite keys, so the index under the path is read and the keys before the bookmark are skipped
+	newTxLogger(stub).Debugf("- listPath path:%s start:%s", redactKey(pathKey), redactKey(startKey))
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(kvPathObjectType, pathAttributes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	page := KeyValuePage{ownerId, []KeyValue{}, ""}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if queryResponse.Key < startKey {
+			continue
+		}
+		_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		name := strings.Join(attributes[1:], "/")
+		if len(page.Records) == pageSize {
+			page.Bookmark = name
+			break
+		}
+		_, record, err := getKeyValue(stub, name, ownerId)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if record != nil {
+			page.Records = append(page.Records, *record)
+		}
+	}
+
+	pageJSONasBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pageJSONasBytes)
+}
+
+// ========================================================
+// parseScanArguments parses the optional pageSize, bookmark and ownerId of the scans, the ownerId is the caller's if empty.
+// ========================================================
+func parseScanArguments(stub shim.ChaincodeStubInterface, args []string) (int, string, string, error) {
+	pageSize := defaultScanPageSize
+	if len(args) > 0 && len(args[0]) > 0 {
+		var err error
+		pageSize, err = strconv.Atoi(args[0])
+		if err != nil || pageSize < 1 || pageSize > maxScanPageSize {
+			return 0, "", "", errors.New(fmt.Sprintf("Invalid pageSize:%s, expecting an integer from 1 to %d.", args[0], maxScanPageSize))
+		}
+	}
+	var bookmark string
+	if len(args) > 1 {
+		bookmark = args[1]
+	}
+	var ownerId string
+	if len(args) > 2 {
+		ownerId = args[2]
+	}
+	if len(ownerId) == 0 {
+		callerId, err := getCallerId(stub)
+		if err != nil {
+			return 0, "", "", err
+		}
+		ownerId = callerId
+	}
+	if err := checkOwnerId("ownerId", ownerId); err != nil {
+		return 0, "", "", err
+	}
+	return pageSize, bookmark, ownerId, nil
+}
+
+// ========================================================
+// scanKeyValues reads a page of variables of the namespace in [startKey, endKey). One more variable is read to know if there
+// is a next page, its name is the bookmark.
+// ========================================================
+func scanKeyValues(stub shim.ChaincodeStubInterface, ownerId string, startKey string, endKey string, pageSize int) ([]byte, error) {
+	newTxLogger(stub).Debugf("- scanKeyValues range:%s - %s", redactKey(startKey), redactKey(endKey))
+	resultsIterator, err := stub.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	page := KeyValuePage{ownerId, []KeyValue{}, ""}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var record KeyValue
+		err = json.Unmarshal(queryResponse.Value, &record)
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Records) == pageSize {
+			page.Bookmark = record.Name
+			break
+		}
+		page.Records = append(page.Records, record)
+	}
+	return json.Marshal(page)
+}
+
+// ========================================================
+// putKeyValuePath writes the path index entry of a variable. It is a blind write, so writes of different variables don't conflict,
+// and variables written before the index existed are indexed by their next write.
+// ========================================================
+func putKeyValuePath(stub shim.ChaincodeStubInterface, ownerId string, name string) error {
+	pathKey, err := stub.CreateCompositeKey(kvPathObjectType, append([]string{ownerId}, strings.Split(name, "/")...))
+	if err != nil {
+		return err
+	}
+	newTxLogger(stub).Debugf("Starting PutState, key:%s", redactKey(pathKey))
+	return stub.PutState(pathKey, []byte{0x00})
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	pb_timestamp "github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Key value schema is used by the generic write, read and cas, every owner has its own namespace.
+// To store this data the key will be: "KV_" + ownerId + "_" + name
+type KeyValue struct {
+	OperationType string                 `json:"operationType"` //operationType is used to distinguish the various types of operations(KV)
+	Owner         string                 `json:"owner"`         //owner of the namespace, the md5 hash value of cert
+	Name          string                 `json:"name"`
+	Value         string                 `json:"value"`
+	Version       int                    `json:"version"`   //starts from 1 and is increased by every write, see cas
+	Writer        string                 `json:"writer"`    //md5 hash value of cert of the last writer
+	ACL           []string               `json:"acl"`       //ownerIds allowed to write besides the owner, only the owner can change it
+	Timestamp     pb_timestamp.Timestamp `json:"timestamp"` //the time of the last write
+}
+
+const kvOperationType = "KV"
+
+// ============================================================================================================================
+// write - generic write of a variable into the namespace of the caller, or of another owner whose ACL has the caller.
+// ============================================================================================================================
+func (t *SimpleChaincode) write(stub shim.ChaincodeStubInterface, args []string, config ChaincodeConfig) pb.Response {
+	newTxLogger(stub).Debugf("starting write")
+	//-------------3 parameters------------
+	//     0       1          2(optional)
+	//   "Name"  "Value"   "OwnerId": namespace to write, the caller's by default
+
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2. name of the variable and value to set")
+	}
+	var ownerId string
+	if len(args) == 3 {
+		ownerId = args[2]
+	}
+	err := consumeCallerQuota(stub, config, "write")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putKeyValue(stub, args[0], ownerId, args[1], -1)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	newTxLogger(stub).Debugf("- end write")
+	return shim.Success(nil)
+}
+
+// ============================================================================================================================
+// cas - compare and swap, writes the value only if the stored version is the expected one. The expectedVersion of a new
+// variable is 0. The new version is returned, so the client can chain the next cas.
+// ============================================================================================================================
+func (t *SimpleChaincode) cas(stub shim.ChaincodeStubInterface, args []string, config ChaincodeConfig) pb.Response {
+	newTxLogger(stub).Debugf("starting cas")
+	//-------------4 parameters------------
+	//     0         1                 2          3(optional)
+	//   "Name"  "ExpectedVersion"  "Value"    "OwnerId": namespace to write, the caller's by default
+
+	if len(args) != 3 && len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 3 to 4 parameters for cas")
+	}
+	expectedVersion, err := strconv.Atoi(args[1])
+	if err != nil || expectedVersion < 0 {
+		return shim.Error("2th argument must be a non-negative numeric string as expectedVersion of cas.")
+	}
+	var ownerId string
+	if len(args) == 4 {
+		ownerId = args[3]
+	}
+	err = consumeCallerQuota(stub, config, "cas")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putKeyValue(stub, args[0], ownerId, args[2], expectedVersion)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	newTxLogger(stub).Debugf("- end cas")
+	return shim.Success([]byte(strconv.Itoa(expectedVersion + 1)))
+}
+
+// ============================================================================================================================
+// read - read a generic variable from the namespace of the caller or of the given owner, returns the value as it was written.
+// ============================================================================================================================
+func (t *SimpleChaincode) read(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	newTxLogger(stub).Debugf("starting read")
+
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting name of the var to query")
+	}
+	var ownerId string
+	if len(args) == 2 {
+		ownerId = args[1]
+	}
+	_, record, err := getKeyValue(stub, args[0], ownerId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	newTxLogger(stub).Debugf("- end read")
+	if record == nil {
+		return shim.Success(nil)
+	}
+	return shim.Success([]byte(record.Value)) //send it onward
+}
+
+// ============================================================================================================================
+// readRecord - read a generic variable with its version, writer and ACL, which are needed for cas.
+// ============================================================================================================================
+func (t *SimpleChaincode) readRecord(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting name of the var and ownerId to query")
+	}
+	var ownerId string
+	if len(args) == 2 {
+		ownerId = args[1]
+	}
+	_, record, err := getKeyValue(stub, args[0], ownerId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if record == nil {
+		return shim.Error(fmt.Sprintf("The variable:%s doesn't exist.", args[0]))
+	}
+	dataJSONasBytes, err := json.Marshal(record)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(dataJSONasBytes)
+}
+
+// ============================================================================================================================
+// setACL - the owner of a variable gives other owners the right to write it, an empty list keeps the variable to the owner.
+// ============================================================================================================================
+func (t *SimpleChaincode) setACL(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//-------------2 parameters------------
+	//     0        1
+	//   "Name"  "Writers": comma separated ownerIds
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 parameters for setACL")
+	}
+	acl := []string{}
+	for _, writer := range strings.Split(args[1], ",") {
+		writer = strings.TrimSpace(writer)
+		if len(writer) == 0 {
+			continue
+		}
+		if err := checkOwnerId("writer", writer); err != nil {
+			return shim.Error(err.Error())
+		}
+		acl = append(acl, writer)
+	}
+
+	key, record, err := getKeyValue(stub, args[0], "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if record == nil {
+		return shim.Error(fmt.Sprintf("The variable:%s doesn't exist, write it before setting its ACL.", args[0]))
+	}
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// the ACL change is a write too, so a pending cas based on the old version fails
+	record.ACL = acl
+	record.Version++
+	record.Writer = record.Owner
+	record.Timestamp = txTimestamp
+
+	dataJSONasBytes, err := json.Marshal(record)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(key), redactRecord(dataJSONasBytes))
+	err = stub.PutState(key, dataJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ========================================================
+// getKeyValue returns the state key and the stored variable of the namespace, the caller's if ownerId is empty.
+// The variable is nil if it was never written.
+// ========================================================
+func getKeyValue(stub shim.ChaincodeStubInterface, name string, ownerId string) (string, *KeyValue, error) {
+	if len(name) == 0 {
+		return "", nil, errors.New("Incorrect name. Expecting non empty name of the variable.")
+	}
+	if len(ownerId) == 0 {
+		callerId, err := getCallerId(stub)
+		if err != nil {
+			return "", nil, err
+		}
+		ownerId = callerId
+	}
+	if err := checkOwnerId("ownerId", ownerId); err != nil {
+		return "", nil, err
+	}
+
+	key := kvOperationType + "_" + ownerId + "_" + name
+	valAsbytes, err := stub.GetState(key)
+	if err != nil {
+		return "", nil, errors.New(fmt.Sprintf("Failed to get state for %s, err:%s", name, err))
+	}
+	if valAsbytes == nil {
+		return key, nil, nil
+	}
+	var record KeyValue
+	err = json.Unmarshal(valAsbytes, &record)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, &record, nil
+}
+
+// ========================================================
+// putKeyValue writes the variable if the caller is its owner or in its ACL. A new variable can only be created by the owner.
+// expectedVersion is checked against the stored version unless it is negative.
+// ========================================================
+func putKeyValue(stub shim.ChaincodeStubInterface, name string, ownerId string, value string, expectedVersion int) error {
+	callerId, err := getCallerId(stub)
+	if err != nil {
+		return err
+	}
+	key, record, err := getKeyValue(stub, name, ownerId)
+	if err != nil {
+		return err
+	}
+	if len(ownerId) == 0 {
+		ownerId = callerId
+	}
+
+	currentVersion := 0
+	if record != nil {
+		currentVersion = record.Version
+	}
+	if expectedVersion >= 0 && expectedVersion != currentVersion {
+		return errors.New(fmt.Sprintf("Version conflict on variable:%s, expected version:%d, current version:%d.", name, expectedVersion, currentVersion))
+	}
+
+	if record == nil {
+		if callerId != ownerId {
+			return errors.New(fmt.Sprintf("The variable:%s doesn't exist, only the owner can create it.", name))
+		}
+		record = &KeyValue{kvOperationType, ownerId, name, "", 0, "", []string{}, pb_timestamp.Timestamp{}}
+	} else if callerId != record.Owner && !containsString(record.ACL, callerId) {
+		return errors.New(fmt.Sprintf("The caller:%s is not allowed to write the variable:%s of owner:%s.",
+			redactOwner(callerId), name, redactOwner(record.Owner)))
+	}
+
+	txTimestamp, err := getTxTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	record.Value = value
+	record.Version = currentVersion + 1
+	record.Writer = callerId
+	record.Timestamp = txTimestamp
+
+	dataJSONasBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	newTxLogger(stub).Debugf("Starting PutState, key:%s, value:%s", redactKey(key), redactRecord(dataJSONasBytes))
+	err = stub.PutState(key, dataJSONasBytes)
+	if err != nil {
+		return err
+	}
+	return putKeyValuePath(stub, record.Owner, name)
+}
+
+// ========================================================
+// getCallerId returns the md5 hash of cert of the caller
+// ========================================================
+func getCallerId(stub shim.ChaincodeStubInterface) (string, error) {
+	idBytes, err := getCert(stub)
+	if err != nil {
+		return "", err
+	}
+	return md5_hash(idBytes)
+}
+
+// consumeCallerQuota counts a call of the function against the quota of the caller
+func consumeCallerQuota(stub shim.ChaincodeStubInterface, config ChaincodeConfig, function string) error {
+	callerId, err := getCallerId(stub)
+	if err != nil {
+		return err
+	}
+	return consumeQuota(stub, config, callerId, function)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
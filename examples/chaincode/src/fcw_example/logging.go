@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Logging config schema is written by Init, so the level and redaction policy survive container restarts
+// and can be changed with a chaincode upgrade.
+// To store this data the key will be: "LoggingConfig"
+type LoggingConfig struct {
+	Level           string `json:"level"`           //one of: CRITICAL, ERROR, WARNING, NOTICE, INFO, DEBUG
+	RedactSensitive bool   `json:"redactSensitive"` //mask sketches, ownerIds and cert subjects in log lines
+}
+
+const loggingConfigKey = "LoggingConfig"
+
+var defaultLoggingConfig = LoggingConfig{"INFO", true}
+
+// logger is the chaincode wide logger, use newTxLogger inside transactions so lines carry the txID.
+var logger = shim.NewLogger("fcw_example")
+
+// activeLoggingConfig is loaded from the ledger at the beginning of every Invoke.
+var activeLoggingConfig = defaultLoggingConfig
+var loggingConfigLock sync.RWMutex
+
+// ========================================================
+// Redaction policy for record payloads and query strings.
+// Each sensitive json field is masked by one of the rules.
+// ========================================================
+const (
+	redactPayload = iota //sketches like HLL and Bloom, only the length is logged
+	redactId             //ownerIds, only a short prefix is logged
+	redactSubject        //org and common name from the subject of cert
+)
+
+var sensitiveFields = map[string]int{
+	"hll":         redactPayload,
+	"bloom":       redactPayload,
+	"owner":       redactId,
+	"targetOwner": redactId,
+	"sponsor":     redactId,
+	"providerId":  redactId,
+	"writer":      redactId,
+	"value":       redactPayload,
+	"orgName":     redactSubject,
+	"commonName":  redactSubject,
+}
+
+// ownerIds are 32 chars of md5 hex string, they also appear inside state keys like DataRegister_<ownerId>_<dataName>.
+// Longer hex runs such as txIDs are left untouched.
+var hexRunPattern = regexp.MustCompile(`[0-9a-f]+`)
+
+// ========================================================
+// setLoggingConfig validates the config and applies it to the shim logger.
+// ========================================================
+func setLoggingConfig(config LoggingConfig) error {
+	level, err := shim.LogLevel(config.Level)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Incorrect log level:%s. Expecting one of CRITICAL, ERROR, WARNING, NOTICE, INFO, DEBUG.", config.Level))
+	}
+	loggingConfigLock.Lock()
+	defer loggingConfigLock.Unlock()
+	logger.SetLevel(level)
+	activeLoggingConfig = config
+	return nil
+}
+
+// ========================================================
+// initLoggingConfig is called by Init with the optional arguments:
+//     0(optional)        1(optional)
+//  "LogLevel"     "RedactSensitive"
+// An argument left out keeps the stored value, so an upgrade without arguments does not reset the config.
+// ========================================================
+func initLoggingConfig(stub shim.ChaincodeStubInterface, args []string) error {
+	config := defaultLoggingConfig
+	configJSONasBytes, err := stub.GetState(loggingConfigKey)
+	if err != nil {
+		return err
+	}
+	if configJSONasBytes != nil {
+		err = json.Unmarshal(configJSONasBytes, &config)
+		if err != nil {
+			return err
+		}
+	}
+	if len(args) > 0 && len(args[0]) > 0 {
+		config.Level = args[0]
+	}
+	if len(args) > 1 && len(args[1]) > 0 {
+		redact, err := strconv.ParseBool(args[1])
+		if err != nil {
+			return errors.New("RedactSensitive argument must be a boolean as redactSensitive of LoggingConfig.")
+		}
+		config.RedactSensitive = redact
+	}
+	if err := setLoggingConfig(config); err != nil {
+		return err
+	}
+
+	configJSONasBytes, err = json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(loggingConfigKey, configJSONasBytes)
+}
+
+// ========================================================
+// loadLoggingConfig reads the config written by Init, falls back to the default if Init never wrote one.
+// ========================================================
+func loadLoggingConfig(stub shim.ChaincodeStubInterface) error {
+	configJSONasBytes, err := stub.GetState(loggingConfigKey)
+	if err != nil {
+		return err
+	}
+	config := defaultLoggingConfig
+	if configJSONasBytes != nil {
+		err = json.Unmarshal(configJSONasBytes, &config)
+		if err != nil {
+			return err
+		}
+	}
+	return setLoggingConfig(config)
+}
+
+func isRedactionEnabled() bool {
+	loggingConfigLock.RLock()
+	defer loggingConfigLock.RUnlock()
+	return activeLoggingConfig.RedactSensitive
+}
+
+// ========================================================
+// txLogger prefixes every line with the txID, so all lines of one transaction can be correlated.
+// ========================================================
+type txLogger struct {
+	txID string
+}
+
+func newTxLogger(stub shim.ChaincodeStubInterface) *txLogger {
+	return &txLogger{stub.GetTxID()}
+}
+
+func (l *txLogger) prefix(format string) string {
+	txID := l.txID
+	if len(txID) > 12 {
+		txID = txID[:12]
+	}
+	return "[" + txID + "] " + format
+}
+
+func (l *txLogger) Debugf(format string, args ...interface{}) {
+	logger.Debugf(l.prefix(format), args...)
+}
+
+func (l *txLogger) Infof(format string, args ...interface{}) {
+	logger.Infof(l.prefix(format), args...)
+}
+
+func (l *txLogger) Warningf(format string, args ...interface{}) {
+	logger.Warningf(l.prefix(format), args...)
+}
+
+func (l *txLogger) Errorf(format string, args ...interface{}) {
+	logger.Errorf(l.prefix(format), args...)
+}
+
+// ========================================================
+// redactRecord returns the record JSON(or a JSON array of records, or a query string) with the sensitive fields masked.
+// ========================================================
+func redactRecord(value []byte) string {
+	if !isRedactionEnabled() {
+		return string(value)
+	}
+	var parsed interface{}
+	err := json.Unmarshal(value, &parsed)
+	if err != nil {
+		return fmt.Sprintf("<%d bytes>", len(value))
+	}
+	redactedJSONasBytes, err := json.Marshal(redactValue(parsed))
+	if err != nil {
+		return fmt.Sprintf("<%d bytes>", len(value))
+	}
+	return string(redactedJSONasBytes)
+}
+
+// redactKey masks the ownerIds inside state keys.
+func redactKey(key string) string {
+	if !isRedactionEnabled() {
+		return key
+	}
+	return maskOwnerIds(key)
+}
+
+// redactOwner masks a single ownerId.
+func redactOwner(ownerId string) string {
+	if !isRedactionEnabled() {
+		return ownerId
+	}
+	return maskOwnerId(ownerId)
+}
+
+func maskOwnerId(ownerId string) string {
+	if len(ownerId) <= 6 {
+		return "***"
+	}
+	return ownerId[:6] + "***"
+}
+
+func maskOwnerIds(s string) string {
+	return hexRunPattern.ReplaceAllStringFunc(s, func(run string) string {
+		if len(run) != 32 {
+			return run
+		}
+		return maskOwnerId(run)
+	})
+}
+
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for field, fieldValue := range v {
+			rule, ok := sensitiveFields[field]
+			if !ok {
+				v[field] = redactValue(fieldValue)
+				continue
+			}
+			s, isString := fieldValue.(string)
+			if !isString {
+				v[field] = redactValue(fieldValue)
+				continue
+			}
+			switch rule {
+			case redactPayload:
+				v[field] = fmt.Sprintf("<%d bytes>", len(s))
+			case redactId:
+				v[field] = maskOwnerId(s)
+			case redactSubject:
+				v[field] = "***"
+			}
+		}
+		return v
+	case []interface{}:
+		for i := range v {
+			v[i] = redactValue(v[i])
+		}
+		return v
+	case string:
+		return maskOwnerIds(v)
+	}
+	return value
+}
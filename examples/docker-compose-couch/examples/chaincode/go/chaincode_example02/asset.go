@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Asset defines a kind of holding, like credits, vouchers or a currency.
+// Balances are kept in the smallest unit, so an amount of "1.25" of an asset with 2 decimals is stored as 125.
+// To store this data the key will be: composite key "Asset" + code
+type Asset struct {
+	Code     string `json:"code"`
+	Decimals int    `json:"decimals"`
+	Issuer   string `json:"issuer"` //creator id of the identity which defined the asset
+}
+
+const (
+	assetObjectType   = "Asset"
+	balanceObjectType = "Balance" //account + asset code, the value is the balance in the smallest unit
+)
+
+// defaultAssetCode is defined by Init, it is used when a function is called without asset code
+const defaultAssetCode = "UNIT"
+
+const maxAssetDecimals = 18
+
+var assetCodePattern = regexp.MustCompile(`^[A-Z0-9]{1,12}$`)
+
+// defineAsset callback defines a new asset issued by the caller, with an optional initial supply credited to an account of the caller.
+// Only the issuer can mint and burn the asset later on.
+// Args: code, decimals, account(optional), supply(optional)
+func (t *SimpleChaincode) defineAsset(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 && len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting code, decimals, account and supply")
+	}
+	issuer, err := getCreatorId(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	decimals, err := strconv.Atoi(args[1])
+	if err != nil {
+		return shim.Error("Invalid decimals " + args[1] + ", expecting a integer value")
+	}
+	asset, err := createAsset(stub, args[0], decimals, issuer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if len(args) == 4 {
+		A, err := getOpenAccount(stub, args[2])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = checkAccountOwner(stub, A)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		supply, err := parseAmount(asset, args[3], true)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = putBalance(stub, A.Name, asset.Code, supply)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = putSupply(stub, asset.Code, supply)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if supply.Sign() > 0 {
+			_, err = putSupplyEvent(stub, asset, 0, supplyEventMint, A.Name, supply, supply, "defineAsset")
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+	}
+	return shim.Success(nil)
+}
+
+// asset callback returns the definition of an asset
+func (t *SimpleChaincode) asset(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting code of the asset")
+	}
+	asset, err := getAsset(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	assetBytes, err := json.Marshal(asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(assetBytes)
+}
+
+func createAsset(stub shim.ChaincodeStubInterface, code string, decimals int, issuer string) (*Asset, error) {
+	if !assetCodePattern.MatchString(code) {
+		return nil, errors.New("Invalid asset code " + code + ", expecting 1 to 12 upper case letters or digits")
+	}
+	if decimals < 0 || decimals > maxAssetDecimals {
+		return nil, fmt.Errorf("Invalid decimals %d, expecting 0 to %d", decimals, maxAssetDecimals)
+	}
+	key, err := stub.CreateCompositeKey(assetObjectType, []string{code})
+	if err != nil {
+		return nil, err
+	}
+	existing, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, errors.New("Asset " + code + " already exists")
+	}
+	asset := &Asset{code, decimals, issuer}
+	assetBytes, err := json.Marshal(asset)
+	if err != nil {
+		return nil, err
+	}
+	return asset, stub.PutState(key, assetBytes)
+}
+
+func getAsset(stub shim.ChaincodeStubInterface, code string) (*Asset, error) {
+	if len(code) == 0 {
+		code = defaultAssetCode
+	}
+	key, err := stub.CreateCompositeKey(assetObjectType, []string{code})
+	if err != nil {
+		return nil, err
+	}
+	assetBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state")
+	}
+	if assetBytes == nil {
+		return nil, errors.New("Asset not found: " + code)
+	}
+	var asset Asset
+	err = json.Unmarshal(assetBytes, &asset)
+	if err != nil {
+		return nil, fmt.Errorf("Stored value of asset %s is invalid: %s", code, err)
+	}
+	return &asset, nil
+}
+
+// getBalance returns the balance in the smallest unit, an account which never held the asset has zero.
+// The credits of an account in delta mode which are not compacted yet are included, see creditBalance.
+// It fails loudly on a corrupted balance instead of treating it as zero.
+func getBalance(stub shim.ChaincodeStubInterface, account string, code string) (*big.Int, error) {
+	key, err := stub.CreateCompositeKey(balanceObjectType, []string{account, code})
+	if err != nil {
+		return nil, err
+	}
+	balanceBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state")
+	}
+	balance := new(big.Int)
+	if balanceBytes != nil {
+		var ok bool
+		balance, ok = balance.SetString(string(balanceBytes), 10)
+		if !ok || balance.Sign() < 0 {
+			return nil, fmt.Errorf("Stored balance %q of account %s in %s is invalid", balanceBytes, account, code)
+		}
+	}
+	credits, _, _, err := getDeltas(stub, account, code, 0)
+	if err != nil {
+		return nil, err
+	}
+	return balance.Add(balance, credits), nil
+}
+
+// putBalance writes the balance returned by getBalance after a change, the credits it included are rolled up into it.
+func putBalance(stub shim.ChaincodeStubInterface, account string, code string, balance *big.Int) error {
+	if balance.Sign() < 0 {
+		return fmt.Errorf("Balance of account %s in %s can not be negative", account, code)
+	}
+	_, deltaKeys, _, err := getDeltas(stub, account, code, 0)
+	if err != nil {
+		return err
+	}
+	for _, deltaKey := range deltaKeys {
+		err = stub.DelState(deltaKey)
+		if err != nil {
+			return err
+		}
+	}
+	key, err := stub.CreateCompositeKey(balanceObjectType, []string{account, code})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, []byte(balance.String()))
+}
+
+// deleteBalance removes an empty balance line of an account
+func deleteBalance(stub shim.ChaincodeStubInterface, account string, code string) error {
+	key, err := stub.CreateCompositeKey(balanceObjectType, []string{account, code})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(key)
+}
+
+// getBalances returns the balances of every asset the account holds, by asset code, including the credits not compacted yet
+func getBalances(stub shim.ChaincodeStubInterface, account string) (map[string]*big.Int, error) {
+	balances := map[string]*big.Int{}
+	for _, objectType := range []string{balanceObjectType, deltaObjectType} {
+		resultsIterator, err := stub.GetStateByPartialCompositeKey(objectType, []string{account})
+		if err != nil {
+			return nil, err
+		}
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return nil, err
+			}
+			_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+			if err != nil {
+				resultsIterator.Close()
+				return nil, err
+			}
+			balance, ok := new(big.Int).SetString(string(queryResponse.Value), 10)
+			if !ok || balance.Sign() < 0 {
+				resultsIterator.Close()
+				return nil, fmt.Errorf("Stored %s %q of account %s in %s is invalid", objectType, queryResponse.Value, account, attributes[1])
+			}
+			if balances[attributes[1]] == nil {
+				balances[attributes[1]] = new(big.Int)
+			}
+			balances[attributes[1]].Add(balances[attributes[1]], balance)
+		}
+		resultsIterator.Close()
+	}
+	return balances, nil
+}
+
+// parseAmount accepts a decimal number of any size with at most the decimals of the asset, and returns it in the smallest unit.
+// Zero is only allowed for initial holdings.
+func parseAmount(asset *Asset, value string, allowZero bool) (*big.Int, error) {
+	parts := strings.Split(value, ".")
+	if len(parts) > 2 || len(parts[0]) == 0 || (len(parts) == 2 && len(parts[1]) == 0) {
+		return nil, errors.New("Invalid amount " + value + ", expecting a decimal value")
+	}
+	fraction := ""
+	if len(parts) == 2 {
+		fraction = parts[1]
+	}
+	if len(fraction) > asset.Decimals {
+		return nil, fmt.Errorf("Invalid amount %s, asset %s has %d decimals", value, asset.Code, asset.Decimals)
+	}
+	fraction += strings.Repeat("0", asset.Decimals-len(fraction))
+	amount, ok := new(big.Int).SetString(parts[0]+fraction, 10)
+	if !ok || strings.ContainsAny(parts[0]+fraction, "+-") {
+		return nil, errors.New("Invalid amount " + value + ", expecting a decimal value")
+	}
+	if amount.Sign() == 0 && !allowZero {
+		return nil, errors.New("Invalid amount " + value + ", expecting a positive value")
+	}
+	return amount, nil
+}
+
+// formatAmount turns an amount in the smallest unit into a decimal string with the decimals of the asset
+func formatAmount(asset *Asset, amount *big.Int) string {
+	digits := new(big.Int).Abs(amount).String()
+	if asset.Decimals > 0 {
+		if len(digits) <= asset.Decimals {
+			digits = strings.Repeat("0", asset.Decimals-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-asset.Decimals] + "." + digits[len(digits)-asset.Decimals:]
+	}
+	if amount.Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
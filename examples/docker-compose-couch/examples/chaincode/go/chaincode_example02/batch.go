@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// TransferLeg is one payment of a batchTransfer, Asset and Memo are optional.
+type TransferLeg struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Amount string `json:"amount"`
+	Asset  string `json:"asset"`
+	Memo   string `json:"memo"`
+}
+
+// LegResult is returned for every leg of a batchTransfer, Status is "applied", "rejected" or "skipped".
+type LegResult struct {
+	Leg              int    `json:"leg"`
+	Status           string `json:"status"`
+	Reason           string `json:"reason,omitempty"`
+	FromBalanceAfter string `json:"fromBalanceAfter,omitempty"`
+	ToBalanceAfter   string `json:"toBalanceAfter,omitempty"`
+}
+
+const maxBatchLegs = 100
+
+// batchState tracks the accounts, assets and balances of a batchTransfer, as writes are not visible to reads in the same transaction.
+// The credits of an account in delta mode are kept by leg and written as deltas, unless a later leg debits that account.
+type batchState struct {
+	accounts       map[string]*Account
+	assets         map[string]*Asset
+	balances       map[string]*big.Int //by balance key
+	credits        []*big.Int          //by leg, credits not folded into balances
+	creditsByKey   map[string][]int    //legs of the credits by balance key
+	creditAccounts []string            //by leg
+}
+
+// batchTransfer callback makes several payments in one transaction, either all the legs are applied or none.
+// The legs are applied in order, so a leg can spend what an earlier leg credited.
+// When a leg is rejected nothing is written and the per-leg results are returned in the error.
+// Args: legs(JSON array of TransferLeg)
+func (t *SimpleChaincode) batchTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting a JSON array of legs")
+	}
+	var legs []TransferLeg
+	err := json.Unmarshal([]byte(args[0]), &legs)
+	if err != nil {
+		return shim.Error("Invalid legs, expecting a JSON array of from, to, amount, asset and memo: " + err.Error())
+	}
+	if len(legs) == 0 || len(legs) > maxBatchLegs {
+		return shim.Error(fmt.Sprintf("Invalid number of legs %d, expecting 1 to %d", len(legs), maxBatchLegs))
+	}
+	initiator, err := getCreatorId(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	state := &batchState{map[string]*Account{}, map[string]*Asset{}, map[string]*big.Int{},
+		make([]*big.Int, len(legs)), map[string][]int{}, make([]string, len(legs))}
+	transfers := make([]*Transfer, len(legs))
+	results := make([]LegResult, len(legs))
+	rejected := false
+	for i, leg := range legs {
+		results[i] = LegResult{i, "skipped", "", "", ""}
+		if rejected {
+			continue
+		}
+		transfer, err := applyLeg(stub, initiator, i, &leg, state)
+		if err != nil {
+			results[i].Status = "rejected"
+			results[i].Reason = err.Error()
+			rejected = true
+			continue
+		}
+		transfers[i] = transfer
+		results[i].Status = "applied"
+		results[i].FromBalanceAfter = transfer.FromBalanceAfter
+		results[i].ToBalanceAfter = transfer.ToBalanceAfter
+	}
+
+	resultsBytes, err := json.Marshal(results)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if rejected {
+		return shim.Error(string(resultsBytes))
+	}
+
+	// Write the state back to the ledger
+	for key, balance := range state.balances {
+		_, attributes, err := stub.SplitCompositeKey(key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = putBalance(stub, attributes[0], attributes[1], balance)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	for i, credit := range state.credits {
+		if credit == nil {
+			continue
+		}
+		err = putDelta(stub, state.creditAccounts[i], transfers[i].Asset, credit, i)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	for _, transfer := range transfers {
+		err = putTransfer(stub, transfer)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	return shim.Success(resultsBytes)
+}
+
+// applyLeg checks the authorization and the balance of one leg, and moves the amount within the tracked balances
+func applyLeg(stub shim.ChaincodeStubInterface, initiator string, i int, leg *TransferLeg, state *batchState) (*Transfer, error) {
+	if leg.From == leg.To {
+		return nil, errors.New("Can not make payment from an account to itself")
+	}
+	for _, name := range []string{leg.From, leg.To} {
+		if state.accounts[name] == nil {
+			A, err := getOpenAccount(stub, name)
+			if err != nil {
+				return nil, err
+			}
+			state.accounts[name] = A
+		}
+	}
+	if state.accounts[leg.From].Owner != initiator {
+		return nil, fmt.Errorf("Only the owner of account %s can debit or close it", leg.From)
+	}
+
+	code := leg.Asset
+	if len(code) == 0 {
+		code = defaultAssetCode
+	}
+	if state.assets[code] == nil {
+		asset, err := getAsset(stub, code)
+		if err != nil {
+			return nil, err
+		}
+		state.assets[code] = asset
+	}
+	asset := state.assets[code]
+	X, err := parseAmount(asset, leg.Amount, false)
+	if err != nil {
+		return nil, err
+	}
+
+	fromKey, err := stub.CreateCompositeKey(balanceObjectType, []string{leg.From, code})
+	if err != nil {
+		return nil, err
+	}
+	toKey, err := stub.CreateCompositeKey(balanceObjectType, []string{leg.To, code})
+	if err != nil {
+		return nil, err
+	}
+	if state.balances[fromKey] == nil {
+		balance, err := getBalance(stub, leg.From, code)
+		if err != nil {
+			return nil, err
+		}
+		// earlier credits of this batch are folded into the balance instead of being written as deltas
+		for _, creditLeg := range state.creditsByKey[fromKey] {
+			balance.Add(balance, state.credits[creditLeg])
+			state.credits[creditLeg] = nil
+		}
+		delete(state.creditsByKey, fromKey)
+		state.balances[fromKey] = balance
+	}
+	if state.balances[fromKey].Cmp(X) < 0 {
+		return nil, fmt.Errorf("Insufficient funds in account %s: balance %s %s, amount %s %s",
+			leg.From, formatAmount(asset, state.balances[fromKey]), code, leg.Amount, code)
+	}
+	state.balances[fromKey].Sub(state.balances[fromKey], X)
+
+	// the balance of an account in delta mode is not read for a credit
+	var toBalance *big.Int
+	if state.balances[toKey] == nil && state.accounts[leg.To].DeltaMode {
+		state.credits[i] = X
+		state.creditAccounts[i] = leg.To
+		state.creditsByKey[toKey] = append(state.creditsByKey[toKey], i)
+	} else {
+		if state.balances[toKey] == nil {
+			balance, err := getBalance(stub, leg.To, code)
+			if err != nil {
+				return nil, err
+			}
+			state.balances[toKey] = balance
+		}
+		state.balances[toKey].Add(state.balances[toKey], X)
+		toBalance = state.balances[toKey]
+	}
+
+	return &Transfer{stub.GetTxID(), i, leg.From, leg.To, code, formatAmount(asset, X), leg.Memo, initiator, "",
+		formatAmount(asset, state.balances[fromKey]), formatBalance(asset, toBalance)}, nil
+}
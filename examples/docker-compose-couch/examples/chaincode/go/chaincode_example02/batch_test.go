@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestExample02_BatchAllOrNothing(t *testing.T) {
+	stub, cc := newTestStub()
+
+	checkInit(t, stub, cc, alice, "a", "100", "b", "0", "c", "0")
+
+	// The second leg overdraws a, so the first one is not applied either
+	message := checkInvokeFails(t, stub, cc, alice, "batchTransfer",
+		`[{"from":"a","to":"b","amount":"60"},{"from":"a","to":"c","amount":"60"}]`)
+	var results []LegResult
+	err := json.Unmarshal([]byte(message), &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Status != "applied" || results[1].Status != "rejected" {
+		fmt.Println("Unexpected leg results", message)
+		t.FailNow()
+	}
+	checkBalance(t, stub, cc, "a", "100", "0")
+	checkBalance(t, stub, cc, "b", "0", "0")
+	checkBalance(t, stub, cc, "c", "0", "0")
+
+	// A leg can spend what an earlier leg credited
+	checkInvoke(t, stub, cc, alice, "batchTransfer",
+		`[{"from":"a","to":"b","amount":"60"},{"from":"b","to":"c","amount":"50"},{"from":"a","to":"c","amount":"40"}]`)
+	checkBalance(t, stub, cc, "a", "0", "0")
+	checkBalance(t, stub, cc, "b", "10", "0")
+	checkBalance(t, stub, cc, "c", "90", "0")
+	checkSupply(t, stub, cc, "100")
+}
@@ -23,8 +23,12 @@ package main
 //hard-coding.
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"strconv"
+	"math/big"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
@@ -34,37 +38,92 @@ import (
 type SimpleChaincode struct {
 }
 
+// Account is bound to the identity which opened it, only that identity can debit it or close it.
+// The balances are kept per asset, see getBalance.
+// To store this data the key will be: the account name
+type Account struct {
+	Name      string `json:"name"`
+	Owner     string `json:"owner"`               //hex of sha256 of the creator identity(msp id and cert) which opened the account
+	Closed    bool   `json:"closed"`              //a closed account keeps its name, so it can not be taken over by someone else
+	DeltaMode bool   `json:"deltaMode,omitempty"` //credits are written as delta keys, see creditBalance
+}
+
 func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	fmt.Println("ex02 Init")
 	_, args := stub.GetFunctionAndParameters()
-	var A, B string    // Entities
-	var Aval, Bval int // Asset holdings
-	var err error
 
-	if len(args) != 4 {
-		return shim.Error("Incorrect number of arguments. Expecting 4")
+	// Pairs of account name and initial holding, like "a" "100" "b" "200".
+	// The accounts are bound to the identity which instantiates the chaincode, the holdings are in the default asset.
+	if len(args)%2 != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting pairs of account name and asset holding")
 	}
 
-	// Initialize the chaincode
-	A = args[0]
-	Aval, err = strconv.Atoi(args[1])
+	owner, err := getCreatorId(stub)
 	if err != nil {
-		return shim.Error("Expecting integer value for asset holding")
+		return shim.Error(err.Error())
 	}
-	B = args[2]
-	Bval, err = strconv.Atoi(args[3])
+
+	// The default asset has no decimals like the integers of the original example, it is kept by an upgrade
+	asset, err := getAsset(stub, defaultAssetCode)
 	if err != nil {
-		return shim.Error("Expecting integer value for asset holding")
+		asset, err = createAsset(stub, defaultAssetCode, 0, owner)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 	}
-	fmt.Printf("Aval = %d, Bval = %d\n", Aval, Bval)
 
-	// Write the state to the ledger
-	err = stub.PutState(A, []byte(strconv.Itoa(Aval)))
+	// The holdings are minted. An account which already exists is left untouched, so an upgrade
+	// which passes the instantiate arguments again does not reset the balances or take over the accounts.
+	// A plain integer left by the original example under a given name is converted into an account of the
+	// upgrading identity holding that integer, the amount in the arguments is then ignored.
+	supply, err := getSupply(stub, asset.Code)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-
-	err = stub.PutState(B, []byte(strconv.Itoa(Bval)))
+	for i := 0; i < len(args); i += 2 {
+		amount, err := parseAmount(asset, args[i+1], true)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		legacy, err := getLegacyHolding(stub, args[i])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if legacy != nil {
+			amount = legacy
+			fmt.Printf("%s = %s, converted\n", args[i], amount)
+		} else {
+			A, err := getAccount(stub, args[i])
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			if A != nil {
+				fmt.Printf("%s exists, kept\n", args[i])
+				continue
+			}
+			fmt.Printf("%s = %s\n", args[i], amount)
+		}
+
+		// Write the state to the ledger
+		err = putAccount(stub, &Account{args[i], owner, false, false})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = putBalance(stub, args[i], asset.Code, amount)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		if amount.Sign() == 0 {
+			continue
+		}
+		supply.Add(supply, amount)
+		_, err = putSupplyEvent(stub, asset, i/2, supplyEventMint, args[i], amount, supply, "Init")
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	err = putSupply(stub, asset.Code, supply)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -79,66 +138,127 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 		// Make payment of X units from A to B
 		return t.invoke(stub, args)
 	} else if function == "delete" {
-		// Deletes an entity from its state
+		// Closes an account, or removes an empty balance line when an asset is given
 		return t.delete(stub, args)
 	} else if function == "query" {
 		// the old "Query" is now implemtned in invoke
 		return t.query(stub, args)
+	} else if function == "OpenAccount" {
+		return t.openAccount(stub, args)
+	} else if function == "CloseAccount" {
+		return t.closeAccount(stub, args)
+	} else if function == "statement" {
+		return t.statement(stub, args)
+	} else if function == "transfer" {
+		return t.transfer(stub, args)
+	} else if function == "defineAsset" {
+		return t.defineAsset(stub, args)
+	} else if function == "asset" {
+		return t.asset(stub, args)
+	} else if function == "batchTransfer" {
+		// Make several payments at once, all of them or none
+		return t.batchTransfer(stub, args)
+	} else if function == "hold" {
+		// Lock funds for beneficiaries until they are released or refunded
+		return t.hold(stub, args)
+	} else if function == "release" {
+		return t.release(stub, args)
+	} else if function == "refund" {
+		return t.refund(stub, args)
+	} else if function == "getHold" {
+		return t.getHoldInfo(stub, args)
+	} else if function == "balance" {
+		return t.balance(stub, args)
+	} else if function == "mint" {
+		// Create or destroy units of an asset, only for the issuer of the asset
+		return t.mint(stub, args)
+	} else if function == "burn" {
+		return t.burn(stub, args)
+	} else if function == "supplyEvents" {
+		return t.supplyEvents(stub, args)
+	} else if function == "checkSupply" {
+		return t.checkSupply(stub, args)
+	} else if function == "setDeltaMode" {
+		// Write the credits of a hot account as deltas, so concurrent payments into it do not conflict
+		return t.setDeltaMode(stub, args)
+	} else if function == "compact" {
+		return t.compact(stub, args)
 	}
 
-	return shim.Error("Invalid invoke function name. Expecting \"invoke\" \"delete\" \"query\"")
+	return shim.Error("Invalid invoke function name. Expecting \"invoke\" \"delete\" \"query\" \"OpenAccount\" \"CloseAccount\" \"statement\" \"transfer\" \"defineAsset\" \"asset\" \"batchTransfer\" \"hold\" \"release\" \"refund\" \"getHold\" \"balance\" \"mint\" \"burn\" \"supplyEvents\" \"checkSupply\" \"setDeltaMode\" \"compact\"")
 }
 
-// Transaction makes payment of X units from A to B
+// Transaction makes payment of X units of an asset from A to B, only the owner of A can make it.
+// Args: A, B, X, memo(optional), asset(optional, the default asset if empty)
 func (t *SimpleChaincode) invoke(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var A, B string    // Entities
-	var Aval, Bval int // Asset holdings
-	var X int          // Transaction value
-	var err error
-
-	if len(args) != 3 {
-		return shim.Error("Incorrect number of arguments. Expecting 3")
+	if len(args) < 3 || len(args) > 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 3 to 5")
+	}
+	if args[0] == args[1] {
+		return shim.Error("Can not make payment from an account to itself")
 	}
 
-	A = args[0]
-	B = args[1]
-
-	// Get the state from the ledger
-	// TODO: will be nice to have a GetAllState call to ledger
-	Avalbytes, err := stub.GetState(A)
+	A, err := getOpenAccount(stub, args[0])
 	if err != nil {
-		return shim.Error("Failed to get state")
+		return shim.Error(err.Error())
 	}
-	if Avalbytes == nil {
-		return shim.Error("Entity not found")
+	B, err := getOpenAccount(stub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkAccountOwner(stub, A)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
-	Aval, _ = strconv.Atoi(string(Avalbytes))
 
-	Bvalbytes, err := stub.GetState(B)
+	var code string
+	if len(args) == 5 {
+		code = args[4]
+	}
+	asset, err := getAsset(stub, code)
 	if err != nil {
-		return shim.Error("Failed to get state")
+		return shim.Error(err.Error())
 	}
-	if Bvalbytes == nil {
-		return shim.Error("Entity not found")
+	Aval, err := getBalance(stub, A.Name, asset.Code)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
-	Bval, _ = strconv.Atoi(string(Bvalbytes))
 
 	// Perform the execution
-	X, err = strconv.Atoi(args[2])
+	X, err := parseAmount(asset, args[2], false)
 	if err != nil {
-		return shim.Error("Invalid transaction amount, expecting a integer value")
+		return shim.Error(err.Error())
+	}
+	if Aval.Cmp(X) < 0 {
+		return shim.Error(fmt.Sprintf("Insufficient funds in account %s: balance %s %s, amount %s %s",
+			A.Name, formatAmount(asset, Aval), asset.Code, args[2], asset.Code))
 	}
-	Aval = Aval - X
-	Bval = Bval + X
-	fmt.Printf("Aval = %d, Bval = %d\n", Aval, Bval)
+	Aval.Sub(Aval, X)
 
 	// Write the state back to the ledger
-	err = stub.PutState(A, []byte(strconv.Itoa(Aval)))
+	err = putBalance(stub, A.Name, asset.Code, Aval)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// Bval is nil when B is in delta mode, the credit is then written without reading the balance of B
+	Bval, err := creditBalance(stub, B, asset.Code, X, 0)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	fmt.Printf("Aval = %s, Bval = %s\n", Aval, Bval)
 
-	err = stub.PutState(B, []byte(strconv.Itoa(Bval)))
+	// Keep the payment as an immutable transfer record
+	var memo string
+	if len(args) > 3 {
+		memo = args[3]
+	}
+	initiator, err := getCreatorId(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putTransfer(stub, &Transfer{stub.GetTxID(), 0, A.Name, B.Name, asset.Code, formatAmount(asset, X), memo, initiator, "",
+		formatAmount(asset, Aval), formatBalance(asset, Bval)})
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -146,49 +266,221 @@ func (t *SimpleChaincode) invoke(stub shim.ChaincodeStubInterface, args []string
 	return shim.Success(nil)
 }
 
-// Deletes an entity from state
+// Opens an account with zero balance bound to the caller
+func (t *SimpleChaincode) openAccount(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 || len(args[0]) == 0 {
+		return shim.Error("Incorrect number of arguments. Expecting name of the account to open")
+	}
+
+	existing, err := getAccount(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existing != nil {
+		return shim.Error("Account " + args[0] + " already exists")
+	}
+
+	owner, err := getCreatorId(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putAccount(stub, &Account{args[0], owner, false, false})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// delete callback closes an account of the caller, or removes one of its empty balance lines.
+// Args: A, asset(optional)
 func (t *SimpleChaincode) delete(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return t.closeAccount(stub, args)
+	}
+
+	A, err := getOpenAccount(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkAccountOwner(stub, A)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	asset, err := getAsset(stub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	Aval, err := getBalance(stub, A.Name, asset.Code)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if Aval.Sign() != 0 {
+		return shim.Error(fmt.Sprintf("Account %s still holds %s %s, can not be deleted", A.Name, formatAmount(asset, Aval), asset.Code))
+	}
+
+	err = deleteBalance(stub, A.Name, asset.Code)
+	if err != nil {
+		return shim.Error("Failed to delete balance")
+	}
+	return shim.Success(nil)
+}
+
+// Closes an account of the caller, the balances of every asset have to be moved out first
+func (t *SimpleChaincode) closeAccount(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	if len(args) != 1 {
 		return shim.Error("Incorrect number of arguments. Expecting 1")
 	}
 
-	A := args[0]
+	A, err := getOpenAccount(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkAccountOwner(stub, A)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	balances, err := getBalances(stub, A.Name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for code, balance := range balances {
+		if balance.Sign() != 0 {
+			return shim.Error(fmt.Sprintf("Account %s still holds %s of %s, can not be closed", A.Name, balance, code))
+		}
+	}
+	held, err := hasHeldBalance(stub, A.Name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if held {
+		return shim.Error("Account " + A.Name + " still has open holds, can not be closed")
+	}
 
-	// Delete the key from the state in ledger
-	err := stub.DelState(A)
+	A.Closed = true
+	err = putAccount(stub, A)
 	if err != nil {
-		return shim.Error("Failed to delete state")
+		return shim.Error("Failed to close account")
 	}
 
 	return shim.Success(nil)
 }
 
-// query callback representing the query of a chaincode
+// query callback representing the query of a chaincode, it returns the available balance in the asset as a decimal string.
+// Args: A, asset(optional, the default asset if empty)
 func (t *SimpleChaincode) query(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var A string // Entities
-	var err error
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting name of the person to query and asset")
+	}
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting name of the person to query")
+	A, err := getAccount(stub, args[0])
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get state for " + args[0] + "\"}"
+		return shim.Error(jsonResp)
+	}
+	if A == nil {
+		jsonResp := "{\"Error\":\"Nil amount for " + args[0] + "\"}"
+		return shim.Error(jsonResp)
 	}
 
-	A = args[0]
+	var code string
+	if len(args) == 2 {
+		code = args[1]
+	}
+	asset, err := getAsset(stub, code)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	Aval, err := getBalance(stub, A.Name, asset.Code)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	amount := formatAmount(asset, Aval)
 
-	// Get the state from the ledger
-	Avalbytes, err := stub.GetState(A)
+	jsonResp := "{\"Name\":\"" + A.Name + "\",\"Asset\":\"" + asset.Code + "\",\"Amount\":\"" + amount + "\"}"
+	fmt.Printf("Query Response:%s\n", jsonResp)
+	return shim.Success([]byte(amount))
+}
+
+// getCreatorId identifies the caller by the hash of its serialized identity, which carries the msp id and the cert
+func getCreatorId(stub shim.ChaincodeStubInterface) (string, error) {
+	creator, err := stub.GetCreator()
 	if err != nil {
-		jsonResp := "{\"Error\":\"Failed to get state for " + A + "\"}"
-		return shim.Error(jsonResp)
+		return "", err
+	}
+	if len(creator) == 0 {
+		return "", errors.New("Failed to get the identity of the caller")
 	}
+	hash := sha256.Sum256(creator)
+	return hex.EncodeToString(hash[:]), nil
+}
 
+// getAccount returns nil if the account does not exist
+func getAccount(stub shim.ChaincodeStubInterface, name string) (*Account, error) {
+	Avalbytes, err := stub.GetState(name)
+	if err != nil {
+		return nil, errors.New("Failed to get state")
+	}
 	if Avalbytes == nil {
-		jsonResp := "{\"Error\":\"Nil amount for " + A + "\"}"
-		return shim.Error(jsonResp)
+		return nil, nil
 	}
+	var A Account
+	err = json.Unmarshal(Avalbytes, &A)
+	if err != nil {
+		if _, ok := new(big.Int).SetString(string(Avalbytes), 10); ok {
+			return nil, errors.New("Account " + name + " still holds the integer of the original example, name it in the Init of an upgrade to convert it")
+		}
+		return nil, fmt.Errorf("Stored value of %s is not an account: %s", name, err)
+	}
+	return &A, nil
+}
 
-	jsonResp := "{\"Name\":\"" + A + "\",\"Amount\":\"" + string(Avalbytes) + "\"}"
-	fmt.Printf("Query Response:%s\n", jsonResp)
-	return shim.Success(Avalbytes)
+// getLegacyHolding returns the integer the original example stored under the account name, nil if the name holds anything else
+func getLegacyHolding(stub shim.ChaincodeStubInterface, name string) (*big.Int, error) {
+	Avalbytes, err := stub.GetState(name)
+	if err != nil {
+		return nil, errors.New("Failed to get state")
+	}
+	holding, ok := new(big.Int).SetString(string(Avalbytes), 10)
+	if !ok {
+		return nil, nil
+	}
+	if holding.Sign() < 0 {
+		return nil, fmt.Errorf("Account %s holds the negative integer %s of the original example, it can not be converted", name, holding)
+	}
+	return holding, nil
+}
+
+func getOpenAccount(stub shim.ChaincodeStubInterface, name string) (*Account, error) {
+	A, err := getAccount(stub, name)
+	if err != nil {
+		return nil, err
+	}
+	if A == nil {
+		return nil, errors.New("Entity not found: " + name)
+	}
+	if A.Closed {
+		return nil, errors.New("Account " + name + " is closed")
+	}
+	return A, nil
+}
+
+func putAccount(stub shim.ChaincodeStubInterface, A *Account) error {
+	Avalbytes, err := json.Marshal(A)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(A.Name, Avalbytes)
+}
+
+func checkAccountOwner(stub shim.ChaincodeStubInterface, A *Account) error {
+	caller, err := getCreatorId(stub)
+	if err != nil {
+		return err
+	}
+	if caller != A.Owner {
+		return errors.New("Only the owner of account " + A.Name + " can debit or close it")
+	}
+	return nil
 }
 
 func main() {
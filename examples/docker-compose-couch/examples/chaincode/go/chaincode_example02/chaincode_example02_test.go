@@ -0,0 +1,222 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	alice = "Org1MSP alice"
+	bob   = "Org1MSP bob"
+)
+
+// testChaincode runs SimpleChaincode with a creator and a tx timestamp, which the MockStub leaves empty.
+// Each transaction is one second after the previous one.
+type testChaincode struct {
+	SimpleChaincode
+	creator string
+	seconds int64
+	txCount int
+}
+
+type testStub struct {
+	shim.ChaincodeStubInterface
+	cc *testChaincode
+}
+
+func (stub *testStub) GetCreator() ([]byte, error) {
+	return []byte(stub.cc.creator), nil
+}
+
+func (stub *testStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return &timestamp.Timestamp{Seconds: stub.cc.seconds}, nil
+}
+
+func (stub *testStub) SetEvent(name string, payload []byte) error {
+	return nil
+}
+
+func (t *testChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return t.SimpleChaincode.Init(&testStub{stub, t})
+}
+
+func (t *testChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	return t.SimpleChaincode.Invoke(&testStub{stub, t})
+}
+
+func newTestStub() (*shim.MockStub, *testChaincode) {
+	cc := &testChaincode{seconds: time.Date(2017, 7, 1, 0, 0, 0, 0, time.UTC).Unix()}
+	return shim.NewMockStub("ex02", cc), cc
+}
+
+func toArgs(args []string) [][]byte {
+	bargs := [][]byte{}
+	for _, arg := range args {
+		bargs = append(bargs, []byte(arg))
+	}
+	return bargs
+}
+
+func nextTx(cc *testChaincode, creator string) string {
+	cc.txCount++
+	cc.seconds++
+	cc.creator = creator
+	return "tx" + strconv.Itoa(cc.txCount)
+}
+
+func checkInit(t *testing.T, stub *shim.MockStub, cc *testChaincode, creator string, args ...string) {
+	res := stub.MockInit(nextTx(cc, creator), toArgs(append([]string{"init"}, args...)))
+	if res.Status != shim.OK {
+		fmt.Println("Init failed", res.Message)
+		t.FailNow()
+	}
+}
+
+func checkInvoke(t *testing.T, stub *shim.MockStub, cc *testChaincode, creator string, args ...string) []byte {
+	res := stub.MockInvoke(nextTx(cc, creator), toArgs(args))
+	if res.Status != shim.OK {
+		fmt.Println("Invoke", args, "failed", res.Message)
+		t.FailNow()
+	}
+	return res.Payload
+}
+
+func checkInvokeFails(t *testing.T, stub *shim.MockStub, cc *testChaincode, creator string, args ...string) string {
+	res := stub.MockInvoke(nextTx(cc, creator), toArgs(args))
+	if res.Status == shim.OK {
+		fmt.Println("Invoke", args, "should have failed")
+		t.FailNow()
+	}
+	return res.Message
+}
+
+func checkBalance(t *testing.T, stub *shim.MockStub, cc *testChaincode, account string, available string, held string) {
+	var balance AccountBalance
+	err := json.Unmarshal(checkInvoke(t, stub, cc, alice, "balance", account), &balance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Available != available || balance.Held != held {
+		fmt.Println("Balance of", account, "is", balance.Available, "held", balance.Held, "expected", available, "held", held)
+		t.FailNow()
+	}
+}
+
+func checkSupply(t *testing.T, stub *shim.MockStub, cc *testChaincode, supply string) {
+	var check SupplyCheck
+	err := json.Unmarshal(checkInvoke(t, stub, cc, alice, "checkSupply"), &check)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !check.Consistent || check.Supply != supply {
+		fmt.Println("Supply", check.Supply, "available", check.Available, "held", check.Held, "expected", supply)
+		t.FailNow()
+	}
+}
+
+func TestExample02_Init(t *testing.T) {
+	stub, cc := newTestStub()
+
+	checkInit(t, stub, cc, alice, "a", "100", "b", "200")
+	checkBalance(t, stub, cc, "a", "100", "0")
+	checkBalance(t, stub, cc, "b", "200", "0")
+	checkSupply(t, stub, cc, "300")
+}
+
+func TestExample02_InitOnUpgradeKeepsAccounts(t *testing.T) {
+	stub, cc := newTestStub()
+
+	checkInit(t, stub, cc, alice, "a", "100", "b", "200")
+	checkInvoke(t, stub, cc, alice, "invoke", "a", "b", "30")
+
+	// The upgrade passes the instantiate arguments again, by another identity
+	checkInit(t, stub, cc, bob, "a", "100", "b", "200", "c", "5")
+	checkBalance(t, stub, cc, "a", "70", "0")
+	checkBalance(t, stub, cc, "b", "230", "0")
+	checkBalance(t, stub, cc, "c", "5", "0")
+	checkSupply(t, stub, cc, "305")
+
+	// a still belongs to alice
+	checkInvokeFails(t, stub, cc, bob, "invoke", "a", "c", "1")
+	checkInvoke(t, stub, cc, alice, "invoke", "a", "c", "1")
+}
+
+func TestExample02_InvokeOwnerOnly(t *testing.T) {
+	stub, cc := newTestStub()
+
+	checkInit(t, stub, cc, alice, "a", "100")
+	checkInvoke(t, stub, cc, bob, "OpenAccount", "b")
+
+	checkInvokeFails(t, stub, cc, bob, "invoke", "a", "b", "10")
+	checkInvokeFails(t, stub, cc, bob, "CloseAccount", "a")
+	checkBalance(t, stub, cc, "a", "100", "0")
+
+	checkInvoke(t, stub, cc, alice, "invoke", "a", "b", "10")
+	checkInvokeFails(t, stub, cc, alice, "invoke", "b", "a", "10")
+	checkInvoke(t, stub, cc, bob, "invoke", "b", "a", "10")
+	checkBalance(t, stub, cc, "a", "100", "0")
+	checkBalance(t, stub, cc, "b", "0", "0")
+}
+
+func TestExample02_InvokeNoNegativeBalance(t *testing.T) {
+	stub, cc := newTestStub()
+
+	checkInit(t, stub, cc, alice, "a", "100", "b", "0")
+	checkInvokeFails(t, stub, cc, alice, "invoke", "a", "b", "101")
+	checkInvokeFails(t, stub, cc, alice, "invoke", "a", "b", "-1")
+	checkInvokeFails(t, stub, cc, alice, "invoke", "a", "b", "0")
+	checkBalance(t, stub, cc, "a", "100", "0")
+
+	checkInvoke(t, stub, cc, alice, "invoke", "a", "b", "100")
+	checkInvokeFails(t, stub, cc, alice, "invoke", "a", "b", "1")
+	checkInvokeFails(t, stub, cc, alice, "burn", "a", "1")
+	checkBalance(t, stub, cc, "a", "0", "0")
+	checkBalance(t, stub, cc, "b", "100", "0")
+	checkSupply(t, stub, cc, "100")
+}
+
+func TestExample02_InitOnUpgradeConvertsIntegers(t *testing.T) {
+	stub, cc := newTestStub()
+
+	// state left by the original example
+	stub.MockTransactionStart("legacy")
+	stub.PutState("a", []byte("90"))
+	stub.PutState("b", []byte("210"))
+	stub.MockTransactionEnd("legacy")
+
+	// b is not named by the upgrade and stays unconverted
+	checkInit(t, stub, cc, alice, "a", "100")
+	checkBalance(t, stub, cc, "a", "90", "0")
+	checkSupply(t, stub, cc, "90")
+	checkInvokeFails(t, stub, cc, alice, "invoke", "a", "b", "1")
+
+	checkInit(t, stub, cc, bob, "b", "200")
+	checkBalance(t, stub, cc, "b", "210", "0")
+	checkSupply(t, stub, cc, "300")
+	checkInvokeFails(t, stub, cc, alice, "invoke", "b", "a", "1")
+	checkInvoke(t, stub, cc, alice, "invoke", "a", "b", "10")
+	checkBalance(t, stub, cc, "b", "220", "0")
+}
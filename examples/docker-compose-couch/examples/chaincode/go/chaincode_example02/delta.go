@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// A popular account credited by many concurrent transactions can be switched to delta mode.
+// Its credits are then written as append-only delta keys without reading the balance, so they never collide on MVCC.
+// The balance is the stored balance plus the sum of the deltas, debits and compact roll the deltas up into the stored balance.
+// To store a delta the key will be: composite key "Delta" + account + asset code + txID + sequence, the value is the amount in the smallest unit
+
+const deltaObjectType = "Delta"
+
+const defaultCompactSize = 500
+
+// CompactResult is returned by compact, Remaining is true when there are more deltas to roll up.
+type CompactResult struct {
+	Account   string `json:"account"`
+	Asset     string `json:"asset"`
+	Compacted int    `json:"compacted"`
+	Balance   string `json:"balance"`
+	Remaining bool   `json:"remaining"`
+}
+
+// setDeltaMode callback switches the way the credits of an account of the caller are written.
+// Args: A, enabled(true or false)
+func (t *SimpleChaincode) setDeltaMode(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting account and enabled")
+	}
+	enabled, err := strconv.ParseBool(args[1])
+	if err != nil {
+		return shim.Error("Invalid enabled " + args[1] + ", expecting true or false")
+	}
+	A, err := getOpenAccount(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkAccountOwner(stub, A)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// The deltas written so far stay part of the balance, so switching off does not need a compact first
+	A.DeltaMode = enabled
+	err = putAccount(stub, A)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// compact callback rolls up to maxDeltas deltas of an account into its stored balance, anyone can call it as the balance does not change.
+// It reads the deltas it rolls up, so a compact can still collide with concurrent credits, just run it again.
+// Args: A, asset(optional, the default asset if empty), maxDeltas(optional)
+func (t *SimpleChaincode) compact(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 1 || len(args) > 3 {
+		return shim.Error("Incorrect number of arguments. Expecting account, asset and maxDeltas")
+	}
+	A, err := getAccount(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if A == nil {
+		return shim.Error("Entity not found: " + args[0])
+	}
+	var code string
+	if len(args) > 1 {
+		code = args[1]
+	}
+	asset, err := getAsset(stub, code)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	maxDeltas := defaultCompactSize
+	if len(args) > 2 && len(args[2]) > 0 {
+		maxDeltas, err = strconv.Atoi(args[2])
+		if err != nil || maxDeltas < 1 {
+			return shim.Error("Invalid maxDeltas " + args[2] + ", expecting a positive integer")
+		}
+	}
+
+	credits, deltaKeys, remaining, err := getDeltas(stub, A.Name, asset.Code, maxDeltas)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := stub.CreateCompositeKey(balanceObjectType, []string{A.Name, asset.Code})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	balanceBytes, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error("Failed to get state")
+	}
+	balance := new(big.Int)
+	if balanceBytes != nil {
+		var ok bool
+		balance, ok = balance.SetString(string(balanceBytes), 10)
+		if !ok || balance.Sign() < 0 {
+			return shim.Error(fmt.Sprintf("Stored balance %q of account %s in %s is invalid", balanceBytes, A.Name, asset.Code))
+		}
+	}
+	if len(deltaKeys) > 0 {
+		balance.Add(balance, credits)
+		err = stub.PutState(key, []byte(balance.String()))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		for _, deltaKey := range deltaKeys {
+			err = stub.DelState(deltaKey)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+	}
+
+	resultBytes, err := json.Marshal(CompactResult{A.Name, asset.Code, len(deltaKeys), formatAmount(asset, balance), remaining})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultBytes)
+}
+
+// creditBalance adds the amount to the balance of the account.
+// For an account in delta mode only a delta key is written and nil is returned, as the balance is not read.
+// seq tells apart the credits of the same account in one transaction.
+func creditBalance(stub shim.ChaincodeStubInterface, A *Account, code string, amount *big.Int, seq int) (*big.Int, error) {
+	if A.DeltaMode {
+		return nil, putDelta(stub, A.Name, code, amount, seq)
+	}
+	balance, err := getBalance(stub, A.Name, code)
+	if err != nil {
+		return nil, err
+	}
+	balance.Add(balance, amount)
+	return balance, putBalance(stub, A.Name, code, balance)
+}
+
+// formatBalance is formatAmount for the balances returned by creditBalance, an unknown balance is empty
+func formatBalance(asset *Asset, balance *big.Int) string {
+	if balance == nil {
+		return ""
+	}
+	return formatAmount(asset, balance)
+}
+
+func putDelta(stub shim.ChaincodeStubInterface, account string, code string, amount *big.Int, seq int) error {
+	if amount.Sign() <= 0 {
+		return fmt.Errorf("Credit of account %s in %s has to be positive", account, code)
+	}
+	key, err := stub.CreateCompositeKey(deltaObjectType, []string{account, code, stub.GetTxID(), legKey(seq)})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, []byte(amount.String()))
+}
+
+// getDeltas returns the sum and the keys of the deltas of the account in the asset.
+// If limit is positive at most limit of them are returned, together with true if there are more.
+func getDeltas(stub shim.ChaincodeStubInterface, account string, code string, limit int) (*big.Int, []string, bool, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(deltaObjectType, []string{account, code})
+	if err != nil {
+		return nil, nil, false, err
+	}
+	defer resultsIterator.Close()
+
+	sum := new(big.Int)
+	keys := []string{}
+	for resultsIterator.HasNext() {
+		if limit > 0 && len(keys) == limit {
+			return sum, keys, true, nil
+		}
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, false, err
+		}
+		amount, ok := new(big.Int).SetString(string(queryResponse.Value), 10)
+		if !ok || amount.Sign() <= 0 {
+			return nil, nil, false, fmt.Errorf("Stored delta %q of account %s in %s is invalid", queryResponse.Value, account, code)
+		}
+		sum.Add(sum, amount)
+		keys = append(keys, queryResponse.Key)
+	}
+	return sum, keys, false, nil
+}
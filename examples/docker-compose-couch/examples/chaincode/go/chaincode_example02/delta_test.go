@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestExample02_DeltaCreditsAndCompact(t *testing.T) {
+	stub, cc := newTestStub()
+
+	checkInit(t, stub, cc, alice, "a", "100", "fee", "0")
+	checkInvokeFails(t, stub, cc, bob, "setDeltaMode", "fee", "true")
+
+	// The credits of fee are written as deltas, they count in the balance and the supply before and after compact
+	checkInvoke(t, stub, cc, alice, "setDeltaMode", "fee", "true")
+	checkInvoke(t, stub, cc, alice, "invoke", "a", "fee", "5")
+	checkInvoke(t, stub, cc, alice, "invoke", "a", "fee", "7")
+	checkInvoke(t, stub, cc, alice, "mint", "fee", "3")
+	checkBalance(t, stub, cc, "fee", "15", "0")
+	checkBalance(t, stub, cc, "a", "88", "0")
+	checkSupply(t, stub, cc, "103")
+
+	var result CompactResult
+	err := json.Unmarshal(checkInvoke(t, stub, cc, bob, "compact", "fee", "", "2"), &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Compacted != 2 || !result.Remaining {
+		fmt.Println("Unexpected compact result", result)
+		t.FailNow()
+	}
+	checkBalance(t, stub, cc, "fee", "15", "0")
+	checkSupply(t, stub, cc, "103")
+
+	// A debit rolls the remaining deltas up
+	checkInvoke(t, stub, cc, alice, "invoke", "fee", "a", "15")
+	checkBalance(t, stub, cc, "fee", "0", "0")
+	checkBalance(t, stub, cc, "a", "103", "0")
+	checkSupply(t, stub, cc, "103")
+}
+
+func TestExample02_StatementOfDeltaCredits(t *testing.T) {
+	stub, cc := newTestStub()
+
+	checkInit(t, stub, cc, alice, "a", "100", "fee", "10")
+	checkInvoke(t, stub, cc, alice, "invoke", "fee", "a", "4")
+	checkInvoke(t, stub, cc, alice, "setDeltaMode", "fee", "true")
+	checkInvoke(t, stub, cc, alice, "invoke", "a", "fee", "5")
+	checkInvoke(t, stub, cc, alice, "invoke", "a", "fee", "7")
+
+	var statement Statement
+	err := json.Unmarshal(checkInvoke(t, stub, cc, alice, "statement", "fee"), &statement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"6", "11", "18"}
+	if len(statement.Entries) != len(expected) {
+		fmt.Println("Unexpected statement", statement)
+		t.FailNow()
+	}
+	for i, entry := range statement.Entries {
+		if entry.BalanceAfter != expected[i] || entry.Derived != (i > 0) {
+			fmt.Println("Line", i, "has balance", entry.BalanceAfter, "derived", entry.Derived, "expected", expected[i])
+			t.FailNow()
+		}
+	}
+
+	// Without a line before it on the page, the balance of a delta credit is unknown
+	err = json.Unmarshal(checkInvoke(t, stub, cc, alice, "statement", "fee", "", "", "1"), &statement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.Unmarshal(checkInvoke(t, stub, cc, alice, "statement", "fee", "", "", "1", statement.Bookmark), &statement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statement.Entries) != 1 || statement.Entries[0].BalanceAfter != "" || statement.Entries[0].Derived {
+		fmt.Println("Unexpected statement page", statement)
+		t.FailNow()
+	}
+}
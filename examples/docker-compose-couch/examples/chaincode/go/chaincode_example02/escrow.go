@@ -0,0 +1,497 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Hold locks funds of an account until they are released to the beneficiaries or refunded to the account.
+// The held amount is moved out of the available balance into the held balance of the account.
+// To store this data the key will be: composite key "Hold" + holdId
+type Hold struct {
+	HoldID        string            `json:"holdId"`
+	From          string            `json:"from"`
+	Asset         string            `json:"asset"`
+	Amount        string            `json:"amount"` //decimal string with the decimals of the asset
+	Beneficiaries []string          `json:"beneficiaries"`
+	Expiry        string            `json:"expiry"` //RFC3339, after it the hold can only be refunded
+	Status        string            `json:"status"` //held, released or refunded
+	Initiator     string            `json:"initiator"`
+	Timestamp     string            `json:"timestamp"`        //tx timestamp of the hold in RFC3339 with nanoseconds
+	Splits        map[string]string `json:"splits,omitempty"` //amounts released to the beneficiaries
+	ClosedTxID    string            `json:"closedTxID,omitempty"`
+}
+
+// AccountBalance is returned by the balance query, it is not stored on chain.
+type AccountBalance struct {
+	Account   string `json:"account"`
+	Asset     string `json:"asset"`
+	Available string `json:"available"`
+	Held      string `json:"held"`
+	Total     string `json:"total"`
+}
+
+const (
+	holdObjectType = "Hold"
+	heldObjectType = "Held" //account + asset code, the value is the held balance in the smallest unit
+)
+
+const (
+	holdStatusHeld     = "held"
+	holdStatusReleased = "released"
+	holdStatusRefunded = "refunded"
+)
+
+// hold callback locks an amount of the caller's account for the beneficiaries until the expiry.
+// Args: from, amount, holdId, beneficiaries(JSON array of accounts), expiry(RFC3339), asset(optional)
+func (t *SimpleChaincode) hold(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 5 && len(args) != 6 {
+		return shim.Error("Incorrect number of arguments. Expecting from, amount, holdId, beneficiaries, expiry and asset")
+	}
+	holdId := args[2]
+	if len(holdId) == 0 {
+		return shim.Error("holdId can not be empty")
+	}
+
+	A, err := getOpenAccount(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkAccountOwner(stub, A)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	existing, err := getHold(stub, holdId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existing != nil {
+		return shim.Error("Hold " + holdId + " already exists")
+	}
+
+	var beneficiaries []string
+	err = json.Unmarshal([]byte(args[3]), &beneficiaries)
+	if err != nil || len(beneficiaries) == 0 {
+		return shim.Error("Invalid beneficiaries " + args[3] + ", expecting a JSON array of accounts")
+	}
+	seen := map[string]bool{}
+	for _, name := range beneficiaries {
+		if name == A.Name {
+			return shim.Error("Can not hold funds of an account for itself")
+		}
+		if seen[name] {
+			return shim.Error("Beneficiary " + name + " is duplicated")
+		}
+		seen[name] = true
+		_, err = getOpenAccount(stub, name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	expiry, err := time.Parse(time.RFC3339, args[4])
+	if err != nil {
+		return shim.Error("Invalid expiry " + args[4] + ", expecting a RFC3339 time")
+	}
+	if !expiry.After(txTime) {
+		return shim.Error("Expiry " + args[4] + " has already passed")
+	}
+
+	var code string
+	if len(args) == 6 {
+		code = args[5]
+	}
+	asset, err := getAsset(stub, code)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	X, err := parseAmount(asset, args[1], false)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	Aval, err := getBalance(stub, A.Name, asset.Code)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if Aval.Cmp(X) < 0 {
+		return shim.Error(fmt.Sprintf("Insufficient funds in account %s: available %s %s, amount %s %s",
+			A.Name, formatAmount(asset, Aval), asset.Code, args[1], asset.Code))
+	}
+	Aheld, err := getHeldBalance(stub, A.Name, asset.Code)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// Move the amount from the available balance to the held balance
+	err = putBalance(stub, A.Name, asset.Code, Aval.Sub(Aval, X))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putHeldBalance(stub, A.Name, asset.Code, Aheld.Add(Aheld, X))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	initiator, err := getCreatorId(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putHold(stub, &Hold{holdId, A.Name, asset.Code, formatAmount(asset, X), beneficiaries,
+		expiry.UTC().Format(time.RFC3339), holdStatusHeld, initiator, txTime.Format(time.RFC3339Nano), nil, ""})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// release callback pays a hold out to its beneficiaries before the expiry, only the owner of the held account can release it.
+// What is not in the splits goes back to the available balance of the held account.
+// Args: holdId, splits(JSON object of beneficiary and amount)
+func (t *SimpleChaincode) release(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting holdId and splits")
+	}
+	H, A, asset, err := getOpenHold(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkAccountOwner(stub, A)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	expiry, err := time.Parse(time.RFC3339, H.Expiry)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !txTime.Before(expiry) {
+		return shim.Error("Hold " + H.HoldID + " expired at " + H.Expiry + ", it can only be refunded")
+	}
+
+	var splits map[string]string
+	err = json.Unmarshal([]byte(args[1]), &splits)
+	if err != nil || len(splits) == 0 {
+		return shim.Error("Invalid splits " + args[1] + ", expecting a JSON object of beneficiary and amount")
+	}
+	held, err := parseAmount(asset, H.Amount, false)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// The beneficiaries are sorted, so the leg numbers are the same on every endorser
+	names := []string{}
+	for name := range splits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	amounts := map[string]*big.Int{}
+	total := new(big.Int)
+	for _, name := range names {
+		if !containsString(H.Beneficiaries, name) {
+			return shim.Error(name + " is not a beneficiary of hold " + H.HoldID)
+		}
+		amounts[name], err = parseAmount(asset, splits[name], false)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		total.Add(total, amounts[name])
+	}
+	if total.Cmp(held) > 0 {
+		return shim.Error(fmt.Sprintf("Splits %s %s are more than the held %s %s",
+			formatAmount(asset, total), asset.Code, H.Amount, asset.Code))
+	}
+
+	Aval, err := getBalance(stub, A.Name, asset.Code)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	Aval.Add(Aval, new(big.Int).Sub(held, total))
+	err = releaseHeld(stub, A.Name, asset.Code, held)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putBalance(stub, A.Name, asset.Code, Aval)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	initiator, err := getCreatorId(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for i, name := range names {
+		B, err := getOpenAccount(stub, name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		Bval, err := creditBalance(stub, B, asset.Code, amounts[name], i)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = putTransfer(stub, &Transfer{stub.GetTxID(), i, A.Name, B.Name, asset.Code, formatAmount(asset, amounts[name]),
+			"release " + H.HoldID, initiator, "", formatAmount(asset, Aval), formatBalance(asset, Bval)})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	H.Status = holdStatusReleased
+	H.Splits = splits
+	H.ClosedTxID = stub.GetTxID()
+	err = putHold(stub, H)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// refund callback gives a hold back to the available balance of the held account.
+// Before the expiry only the owner of the held account can refund it, after the expiry anyone can, as the funds only go back to the payer.
+// Args: holdId
+func (t *SimpleChaincode) refund(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting holdId")
+	}
+	H, A, asset, err := getOpenHold(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	expiry, err := time.Parse(time.RFC3339, H.Expiry)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if txTime.Before(expiry) {
+		err = checkAccountOwner(stub, A)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	held, err := parseAmount(asset, H.Amount, false)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = releaseHeld(stub, A.Name, asset.Code, held)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	_, err = creditBalance(stub, A, asset.Code, held, 0)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	H.Status = holdStatusRefunded
+	H.ClosedTxID = stub.GetTxID()
+	err = putHold(stub, H)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// getHoldInfo callback returns a hold
+func (t *SimpleChaincode) getHoldInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting holdId")
+	}
+	H, err := getHold(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if H == nil {
+		return shim.Error("Hold not found: " + args[0])
+	}
+	holdBytes, err := json.Marshal(H)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(holdBytes)
+}
+
+// balance callback returns the available and the held balance of an account in an asset.
+// Args: A, asset(optional, the default asset if empty)
+func (t *SimpleChaincode) balance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting account and asset")
+	}
+	A, err := getAccount(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if A == nil {
+		return shim.Error("Entity not found: " + args[0])
+	}
+	var code string
+	if len(args) == 2 {
+		code = args[1]
+	}
+	asset, err := getAsset(stub, code)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	available, err := getBalance(stub, A.Name, asset.Code)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	held, err := getHeldBalance(stub, A.Name, asset.Code)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	total := new(big.Int).Add(available, held)
+
+	balanceBytes, err := json.Marshal(AccountBalance{A.Name, asset.Code, formatAmount(asset, available),
+		formatAmount(asset, held), formatAmount(asset, total)})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(balanceBytes)
+}
+
+// getHold returns nil if the hold does not exist
+func getHold(stub shim.ChaincodeStubInterface, holdId string) (*Hold, error) {
+	key, err := stub.CreateCompositeKey(holdObjectType, []string{holdId})
+	if err != nil {
+		return nil, err
+	}
+	holdBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state")
+	}
+	if holdBytes == nil {
+		return nil, nil
+	}
+	var H Hold
+	err = json.Unmarshal(holdBytes, &H)
+	if err != nil {
+		return nil, fmt.Errorf("Stored value of hold %s is invalid: %s", holdId, err)
+	}
+	return &H, nil
+}
+
+// getOpenHold returns a hold which is neither released nor refunded, together with its account and asset
+func getOpenHold(stub shim.ChaincodeStubInterface, holdId string) (*Hold, *Account, *Asset, error) {
+	H, err := getHold(stub, holdId)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if H == nil {
+		return nil, nil, nil, errors.New("Hold not found: " + holdId)
+	}
+	if H.Status != holdStatusHeld {
+		return nil, nil, nil, errors.New("Hold " + holdId + " is already " + H.Status)
+	}
+	A, err := getAccount(stub, H.From)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if A == nil {
+		return nil, nil, nil, errors.New("Entity not found: " + H.From)
+	}
+	asset, err := getAsset(stub, H.Asset)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return H, A, asset, nil
+}
+
+func putHold(stub shim.ChaincodeStubInterface, H *Hold) error {
+	key, err := stub.CreateCompositeKey(holdObjectType, []string{H.HoldID})
+	if err != nil {
+		return err
+	}
+	holdBytes, err := json.Marshal(H)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, holdBytes)
+}
+
+func getHeldBalance(stub shim.ChaincodeStubInterface, account string, code string) (*big.Int, error) {
+	key, err := stub.CreateCompositeKey(heldObjectType, []string{account, code})
+	if err != nil {
+		return nil, err
+	}
+	heldBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state")
+	}
+	if heldBytes == nil {
+		return new(big.Int), nil
+	}
+	held, ok := new(big.Int).SetString(string(heldBytes), 10)
+	if !ok || held.Sign() < 0 {
+		return nil, fmt.Errorf("Stored held balance %q of account %s in %s is invalid", heldBytes, account, code)
+	}
+	return held, nil
+}
+
+// putHeldBalance removes the key of an empty held balance, so closeAccount only has to look at the remaining ones
+func putHeldBalance(stub shim.ChaincodeStubInterface, account string, code string, held *big.Int) error {
+	if held.Sign() < 0 {
+		return fmt.Errorf("Held balance of account %s in %s can not be negative", account, code)
+	}
+	key, err := stub.CreateCompositeKey(heldObjectType, []string{account, code})
+	if err != nil {
+		return err
+	}
+	if held.Sign() == 0 {
+		return stub.DelState(key)
+	}
+	return stub.PutState(key, []byte(held.String()))
+}
+
+// releaseHeld takes the amount of a closed hold out of the held balance of the account
+func releaseHeld(stub shim.ChaincodeStubInterface, account string, code string, amount *big.Int) error {
+	held, err := getHeldBalance(stub, account, code)
+	if err != nil {
+		return err
+	}
+	if held.Cmp(amount) < 0 {
+		return fmt.Errorf("Held balance of account %s in %s is less than the hold", account, code)
+	}
+	return putHeldBalance(stub, account, code, held.Sub(held, amount))
+}
+
+// hasHeldBalance returns true if any hold of the account is still open
+func hasHeldBalance(stub shim.ChaincodeStubInterface, account string) (bool, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(heldObjectType, []string{account})
+	if err != nil {
+		return false, err
+	}
+	defer resultsIterator.Close()
+	return resultsIterator.HasNext(), nil
+}
+
+func getTxTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(txTimestamp.Seconds, int64(txTimestamp.Nanos)).UTC(), nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
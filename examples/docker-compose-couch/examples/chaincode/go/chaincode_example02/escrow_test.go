@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExample02_HoldReleaseRefund(t *testing.T) {
+	stub, cc := newTestStub()
+
+	checkInit(t, stub, cc, alice, "a", "100", "b", "0", "c", "0")
+	expiry := time.Unix(cc.seconds+3600, 0).UTC().Format(time.RFC3339)
+
+	checkInvokeFails(t, stub, cc, alice, "hold", "a", "101", "h1", `["b","c"]`, expiry)
+	checkInvoke(t, stub, cc, alice, "hold", "a", "60", "h1", `["b","c"]`, expiry)
+	checkBalance(t, stub, cc, "a", "40", "60")
+	checkSupply(t, stub, cc, "100")
+
+	// The held funds can not be spent, and only the owner of a can release them
+	checkInvokeFails(t, stub, cc, alice, "invoke", "a", "b", "41")
+	checkInvokeFails(t, stub, cc, bob, "release", "h1", `{"b":"10"}`)
+	checkInvokeFails(t, stub, cc, alice, "release", "h1", `{"b":"50","c":"11"}`)
+
+	// What is not in the splits goes back to a
+	checkInvoke(t, stub, cc, alice, "release", "h1", `{"b":"20","c":"30"}`)
+	checkBalance(t, stub, cc, "a", "50", "0")
+	checkBalance(t, stub, cc, "b", "20", "0")
+	checkBalance(t, stub, cc, "c", "30", "0")
+	checkInvokeFails(t, stub, cc, alice, "release", "h1", `{"b":"1"}`)
+	checkInvokeFails(t, stub, cc, alice, "refund", "h1")
+	checkSupply(t, stub, cc, "100")
+
+	// Before the expiry only alice can refund, after it anyone can
+	checkInvoke(t, stub, cc, alice, "hold", "a", "50", "h2", `["b"]`, expiry)
+	checkBalance(t, stub, cc, "a", "0", "50")
+	checkInvokeFails(t, stub, cc, bob, "refund", "h2")
+	cc.seconds += 3600
+	checkInvokeFails(t, stub, cc, alice, "release", "h2", `{"b":"1"}`)
+	checkInvoke(t, stub, cc, bob, "refund", "h2")
+	checkBalance(t, stub, cc, "a", "50", "0")
+	checkBalance(t, stub, cc, "b", "20", "0")
+	checkSupply(t, stub, cc, "100")
+}
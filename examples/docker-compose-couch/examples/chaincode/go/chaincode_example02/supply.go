@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// SupplyEvent is the immutable record of a change of the total supply of an asset, it is never overwritten.
+// It is also sent as the "supply" chaincode event by mint and burn.
+// To store this data the key will be: composite key "SupplyEvent" + asset code + timestamp + txID + sequence
+type SupplyEvent struct {
+	TxID        string `json:"txID"`
+	Seq         int    `json:"seq"` //position of the change within the transaction
+	Asset       string `json:"asset"`
+	Type        string `json:"type"` //mint or burn
+	Account     string `json:"account"`
+	Amount      string `json:"amount"`      //decimal string with the decimals of the asset
+	SupplyAfter string `json:"supplyAfter"` //total supply right after the change
+	Memo        string `json:"memo"`
+	Initiator   string `json:"initiator"`
+	Timestamp   string `json:"timestamp"` //tx timestamp in RFC3339 with nanoseconds
+}
+
+// SupplyEvents is returned by the supplyEvents query, pass Bookmark to the next call until it is empty.
+type SupplyEvents struct {
+	Asset    string        `json:"asset"`
+	Events   []SupplyEvent `json:"events"`
+	Bookmark string        `json:"bookmark,omitempty"`
+}
+
+// SupplyCheck is returned by the checkSupply query, Consistent is true when the balances add up to the supply.
+type SupplyCheck struct {
+	Asset      string `json:"asset"`
+	Supply     string `json:"supply"`
+	Available  string `json:"available"` //sum of the available balances of every account
+	Held       string `json:"held"`      //sum of the held balances of every account
+	Accounts   int    `json:"accounts"`
+	Consistent bool   `json:"consistent"`
+}
+
+const (
+	supplyObjectType      = "Supply" //asset code, the value is the total supply in the smallest unit
+	supplyEventObjectType = "SupplyEvent"
+)
+
+const (
+	supplyEventMint = "mint"
+	supplyEventBurn = "burn"
+)
+
+const supplyEventName = "supply"
+
+const defaultSupplyEventsPageSize = 50
+
+// mint callback creates new units of an asset in an account, only the issuer of the asset can mint.
+// Args: account, amount, asset(optional, the default asset if empty), memo(optional)
+func (t *SimpleChaincode) mint(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.changeSupply(stub, supplyEventMint, args)
+}
+
+// burn callback destroys units of an asset held by an account of the issuer, only the issuer of the asset can burn.
+// Args: account, amount, asset(optional, the default asset if empty), memo(optional)
+func (t *SimpleChaincode) burn(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.changeSupply(stub, supplyEventBurn, args)
+}
+
+func (t *SimpleChaincode) changeSupply(stub shim.ChaincodeStubInterface, eventType string, args []string) pb.Response {
+	if len(args) < 2 || len(args) > 4 {
+		return shim.Error("Incorrect number of arguments. Expecting account, amount, asset and memo")
+	}
+	var code, memo string
+	if len(args) > 2 {
+		code = args[2]
+	}
+	if len(args) > 3 {
+		memo = args[3]
+	}
+	asset, err := getAsset(stub, code)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	initiator, err := getCreatorId(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if initiator != asset.Issuer {
+		return shim.Error("Only the issuer of asset " + asset.Code + " can " + eventType + " it")
+	}
+
+	A, err := getOpenAccount(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	X, err := parseAmount(asset, args[1], false)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	delta := new(big.Int).Set(X)
+	if eventType == supplyEventBurn {
+		// Units held by someone else have to be paid back to the issuer first
+		err = checkAccountOwner(stub, A)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		Aval, err := getBalance(stub, A.Name, asset.Code)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if Aval.Cmp(X) < 0 {
+			return shim.Error(fmt.Sprintf("Insufficient funds in account %s: available %s %s, amount %s %s",
+				A.Name, formatAmount(asset, Aval), asset.Code, args[1], asset.Code))
+		}
+		delta.Neg(delta)
+		err = putBalance(stub, A.Name, asset.Code, Aval.Add(Aval, delta))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	} else {
+		_, err = creditBalance(stub, A, asset.Code, X, 0)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	supply, err := getSupply(stub, asset.Code)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putSupply(stub, asset.Code, supply.Add(supply, delta))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	event, err := putSupplyEvent(stub, asset, 0, eventType, A.Name, X, supply, memo)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.SetEvent(supplyEventName, eventBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// supplyEvents callback lists the supply changes of an asset in time order.
+// Args: asset, pageSize(optional), bookmark(optional)
+func (t *SimpleChaincode) supplyEvents(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 1 || len(args) > 3 {
+		return shim.Error("Incorrect number of arguments. Expecting asset, pageSize and bookmark")
+	}
+	asset, err := getAsset(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pageSize := defaultSupplyEventsPageSize
+	if len(args) > 1 && len(args[1]) > 0 {
+		pageSize, err = strconv.Atoi(args[1])
+		if err != nil || pageSize < 1 {
+			return shim.Error("Invalid pageSize " + args[1] + ", expecting a positive integer")
+		}
+	}
+	// the bookmark is "timestamp~txID~seq" of the first event of the next page
+	startAttributes := []string{asset.Code}
+	if len(args) > 2 && len(args[2]) > 0 {
+		startAttributes = append(startAttributes, strings.Split(args[2], "~")...)
+		if len(startAttributes) != 4 {
+			return shim.Error("Invalid bookmark " + args[2])
+		}
+	}
+
+	startKey, err := stub.CreateCompositeKey(supplyEventObjectType, startAttributes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// range scans do not take composite keys, so the events of the asset are read and the ones before the bookmark skipped
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(supplyEventObjectType, []string{asset.Code})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	events := SupplyEvents{asset.Code, []SupplyEvent{}, ""}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if queryResponse.Key < startKey {
+			continue
+		}
+		if len(events.Events) == pageSize {
+			_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			events.Bookmark = strings.Join(attributes[1:], "~")
+			break
+		}
+		var event SupplyEvent
+		err = json.Unmarshal(queryResponse.Value, &event)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		events.Events = append(events.Events, event)
+	}
+
+	eventsBytes, err := json.Marshal(events)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(eventsBytes)
+}
+
+// checkSupply callback adds up the available and held balances of every account in an asset and compares them to the supply.
+// The deltas of the accounts in delta mode are part of the available balances.
+// Args: asset(optional, the default asset if empty)
+func (t *SimpleChaincode) checkSupply(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) > 1 {
+		return shim.Error("Incorrect number of arguments. Expecting asset")
+	}
+	var code string
+	if len(args) == 1 {
+		code = args[0]
+	}
+	asset, err := getAsset(stub, code)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	supply, err := getSupply(stub, asset.Code)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	accounts := map[string]bool{}
+	sums := map[string]*big.Int{}
+	sums[balanceObjectType] = new(big.Int)
+	sums[heldObjectType] = new(big.Int)
+	for _, objectType := range []string{balanceObjectType, deltaObjectType, heldObjectType} {
+		sum := sums[objectType]
+		if objectType == deltaObjectType {
+			sum = sums[balanceObjectType]
+		}
+		resultsIterator, err := stub.GetStateByPartialCompositeKey(objectType, []string{})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return shim.Error(err.Error())
+			}
+			_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+			if err != nil {
+				resultsIterator.Close()
+				return shim.Error(err.Error())
+			}
+			if len(attributes) < 2 || attributes[1] != asset.Code {
+				continue
+			}
+			value, ok := new(big.Int).SetString(string(queryResponse.Value), 10)
+			if !ok || value.Sign() < 0 {
+				resultsIterator.Close()
+				return shim.Error(fmt.Sprintf("Stored %s %q of account %s in %s is invalid", objectType, queryResponse.Value, attributes[0], asset.Code))
+			}
+			if value.Sign() > 0 {
+				accounts[attributes[0]] = true
+			}
+			sum.Add(sum, value)
+		}
+		resultsIterator.Close()
+	}
+	total := new(big.Int).Add(sums[balanceObjectType], sums[heldObjectType])
+
+	check := SupplyCheck{asset.Code, formatAmount(asset, supply), formatAmount(asset, sums[balanceObjectType]),
+		formatAmount(asset, sums[heldObjectType]), len(accounts), total.Cmp(supply) == 0}
+	checkBytes, err := json.Marshal(check)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(checkBytes)
+}
+
+// getSupply returns the total supply of the asset in the smallest unit
+func getSupply(stub shim.ChaincodeStubInterface, code string) (*big.Int, error) {
+	key, err := stub.CreateCompositeKey(supplyObjectType, []string{code})
+	if err != nil {
+		return nil, err
+	}
+	supplyBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state")
+	}
+	if supplyBytes == nil {
+		return new(big.Int), nil
+	}
+	supply, ok := new(big.Int).SetString(string(supplyBytes), 10)
+	if !ok || supply.Sign() < 0 {
+		return nil, fmt.Errorf("Stored supply %q of %s is invalid", supplyBytes, code)
+	}
+	return supply, nil
+}
+
+// putSupply writes the total supply of the asset.
+// Writes are not visible to reads in the same transaction, so a transaction changing the supply several times has to add them up first.
+func putSupply(stub shim.ChaincodeStubInterface, code string, supply *big.Int) error {
+	if supply.Sign() < 0 {
+		return fmt.Errorf("Supply of %s can not be negative", code)
+	}
+	key, err := stub.CreateCompositeKey(supplyObjectType, []string{code})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, []byte(supply.String()))
+}
+
+// putSupplyEvent records one change of the supply, seq tells apart the changes of the same transaction
+func putSupplyEvent(stub shim.ChaincodeStubInterface, asset *Asset, seq int, eventType string, account string,
+	amount *big.Int, supply *big.Int, memo string) (*SupplyEvent, error) {
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	initiator, err := getCreatorId(stub)
+	if err != nil {
+		return nil, err
+	}
+	event := &SupplyEvent{stub.GetTxID(), seq, asset.Code, eventType, account, formatAmount(asset, amount),
+		formatAmount(asset, supply), memo, initiator,
+		time.Unix(txTimestamp.Seconds, int64(txTimestamp.Nanos)).UTC().Format(time.RFC3339Nano)}
+
+	key, err := stub.CreateCompositeKey(supplyEventObjectType,
+		[]string{asset.Code, timestampKey(txTimestamp.Seconds, txTimestamp.Nanos), event.TxID, legKey(seq)})
+	if err != nil {
+		return nil, err
+	}
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return event, stub.PutState(key, eventBytes)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func checkSupplyEvents(t *testing.T, payload []byte, supplies ...string) SupplyEvents {
+	var events SupplyEvents
+	err := json.Unmarshal(payload, &events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events.Events) != len(supplies) {
+		fmt.Println("There are", len(events.Events), "supply events, expected", len(supplies))
+		t.FailNow()
+	}
+	for i, event := range events.Events {
+		if event.SupplyAfter != supplies[i] {
+			fmt.Println("Event", i, "has supply", event.SupplyAfter, "expected", supplies[i])
+			t.FailNow()
+		}
+	}
+	return events
+}
+
+func TestExample02_MintBurn(t *testing.T) {
+	stub, cc := newTestStub()
+
+	checkInit(t, stub, cc, alice, "a", "100")
+	checkInvoke(t, stub, cc, bob, "OpenAccount", "b")
+
+	// Only the issuer mints and burns, and only from its own accounts
+	checkInvokeFails(t, stub, cc, bob, "mint", "b", "10")
+	checkInvoke(t, stub, cc, alice, "mint", "b", "50")
+	checkInvokeFails(t, stub, cc, alice, "burn", "b", "10")
+	checkInvokeFails(t, stub, cc, bob, "burn", "b", "10")
+	checkInvoke(t, stub, cc, alice, "burn", "a", "30")
+	checkInvokeFails(t, stub, cc, alice, "burn", "a", "71")
+	checkBalance(t, stub, cc, "a", "70", "0")
+	checkBalance(t, stub, cc, "b", "50", "0")
+	checkSupply(t, stub, cc, "120")
+}
+
+func TestExample02_SupplyEvents(t *testing.T) {
+	stub, cc := newTestStub()
+
+	checkInit(t, stub, cc, alice, "a", "100", "b", "200")
+	checkInvoke(t, stub, cc, alice, "mint", "a", "50", "", "bonus")
+	checkInvoke(t, stub, cc, alice, "burn", "b", "25")
+
+	events := checkSupplyEvents(t, checkInvoke(t, stub, cc, alice, "supplyEvents", "UNIT"), "100", "300", "350", "325")
+	if events.Events[2].Type != supplyEventMint || events.Events[2].Memo != "bonus" || events.Events[3].Type != supplyEventBurn {
+		fmt.Println("Unexpected events", events.Events)
+		t.FailNow()
+	}
+
+	// The pages follow each other through the bookmark
+	events = checkSupplyEvents(t, checkInvoke(t, stub, cc, alice, "supplyEvents", "UNIT", "3"), "100", "300", "350")
+	events = checkSupplyEvents(t, checkInvoke(t, stub, cc, alice, "supplyEvents", "UNIT", "3", events.Bookmark), "325")
+	if len(events.Bookmark) > 0 {
+		fmt.Println("The last page has the bookmark", events.Bookmark)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Transfer is the immutable record of one payment, it is never overwritten.
+// To store this data the key will be: composite key "Transfer" + txID + leg
+type Transfer struct {
+	TxID             string `json:"txID"`
+	Leg              int    `json:"leg"` //position of the payment within the transaction, 0 for a single payment
+	From             string `json:"from"`
+	To               string `json:"to"`
+	Asset            string `json:"asset"`  //code of the asset
+	Amount           string `json:"amount"` //decimal string with the decimals of the asset
+	Memo             string `json:"memo"`
+	Initiator        string `json:"initiator"`        //creator id of the identity which made the payment
+	Timestamp        string `json:"timestamp"`        //tx timestamp in RFC3339 with nanoseconds
+	FromBalanceAfter string `json:"fromBalanceAfter"` //balance of From right after the payment
+	ToBalanceAfter   string `json:"toBalanceAfter"`   //balance of To right after the payment, empty if To is in delta mode
+}
+
+// The credit of an account in delta mode does not read the balance, so its transfer has no ToBalanceAfter.
+// The statement derives the balance of such a line from the line before it in the same asset: the balance after it plus
+// this credit. Mints, burns and holds are not lines of the statement, so a derived balance misses the ones in between,
+// and a credit with no line before it on the page is left without balance. A line with a recorded balance is always exact.
+
+// StatementEntry is one line of the statement of an account, it is not stored on chain.
+type StatementEntry struct {
+	TxID         string `json:"txID"`
+	Leg          int    `json:"leg"`
+	Timestamp    string `json:"timestamp"`
+	Direction    string `json:"direction"` //debit or credit
+	Counterparty string `json:"counterparty"`
+	Asset        string `json:"asset"`
+	Amount       string `json:"amount"`
+	Memo         string `json:"memo"`
+	Initiator    string `json:"initiator"`
+	BalanceAfter string `json:"balanceAfter"`      //running balance of the account in the asset after this line, empty if unknown
+	Derived      bool   `json:"derived,omitempty"` //BalanceAfter is derived from the line before, as the credit was written as a delta
+}
+
+// Statement is returned by the statement query, pass Bookmark to the next call until it is empty.
+type Statement struct {
+	Account  string           `json:"account"`
+	Entries  []StatementEntry `json:"entries"`
+	Bookmark string           `json:"bookmark,omitempty"`
+}
+
+const (
+	transferObjectType  = "Transfer"
+	statementObjectType = "Statement" //index: account + timestamp + txID + leg, the value is empty
+)
+
+const defaultStatementPageSize = 50
+
+// sortable forms of the tx timestamp and the leg, so the index keys are in time order
+func timestampKey(seconds int64, nanos int32) string {
+	return fmt.Sprintf("%012d.%09d", seconds, nanos)
+}
+
+func legKey(leg int) string {
+	return fmt.Sprintf("%04d", leg)
+}
+
+// putTransfer writes the transfer record together with the statement index of both accounts
+func putTransfer(stub shim.ChaincodeStubInterface, transfer *Transfer) error {
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return err
+	}
+	transfer.Timestamp = time.Unix(txTimestamp.Seconds, int64(txTimestamp.Nanos)).UTC().Format(time.RFC3339Nano)
+
+	key, err := stub.CreateCompositeKey(transferObjectType, []string{transfer.TxID, legKey(transfer.Leg)})
+	if err != nil {
+		return err
+	}
+	existing, err := stub.GetState(key)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return errors.New("Transfer " + transfer.TxID + " already exists")
+	}
+	transferBytes, err := json.Marshal(transfer)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(key, transferBytes)
+	if err != nil {
+		return err
+	}
+
+	for _, account := range []string{transfer.From, transfer.To} {
+		indexKey, err := stub.CreateCompositeKey(statementObjectType,
+			[]string{account, timestampKey(txTimestamp.Seconds, txTimestamp.Nanos), transfer.TxID, legKey(transfer.Leg)})
+		if err != nil {
+			return err
+		}
+		err = stub.PutState(indexKey, []byte{0x00})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getTransfers returns all the legs of the transaction
+func getTransfers(stub shim.ChaincodeStubInterface, txID string) ([]Transfer, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(transferObjectType, []string{txID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	transfers := []Transfer{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var transfer Transfer
+		err = json.Unmarshal(queryResponse.Value, &transfer)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, transfer)
+	}
+	return transfers, nil
+}
+
+// transfer callback looks up the payments made by a transaction
+func (t *SimpleChaincode) transfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 || len(args[0]) == 0 {
+		return shim.Error("Incorrect number of arguments. Expecting txID of the transfer")
+	}
+
+	transfers, err := getTransfers(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(transfers) == 0 {
+		return shim.Error("Transfer not found: " + args[0])
+	}
+	transfersBytes, err := json.Marshal(transfers)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(transfersBytes)
+}
+
+// statement callback lists the payments of an account between two times with the running balance.
+// Args: account, from(RFC3339, optional), to(RFC3339, optional), pageSize(optional), bookmark(optional)
+func (t *SimpleChaincode) statement(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 1 || len(args) > 5 || len(args[0]) == 0 {
+		return shim.Error("Incorrect number of arguments. Expecting account, from, to, pageSize and bookmark")
+	}
+	account := args[0]
+
+	A, err := getAccount(stub, account)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if A == nil {
+		return shim.Error("Entity not found: " + account)
+	}
+
+	from := timestampKey(0, 0)
+	if len(args) > 1 && len(args[1]) > 0 {
+		fromTime, err := time.Parse(time.RFC3339, args[1])
+		if err != nil {
+			return shim.Error("Invalid from " + args[1] + ", expecting a RFC3339 time")
+		}
+		from = timestampKey(fromTime.Unix(), int32(fromTime.Nanosecond()))
+	}
+	// the end of the range is exclusive, so the next second after "to" is used to keep "to" itself in the statement
+	to := timestampKey(999999999999, 0)
+	if len(args) > 2 && len(args[2]) > 0 {
+		toTime, err := time.Parse(time.RFC3339, args[2])
+		if err != nil {
+			return shim.Error("Invalid to " + args[2] + ", expecting a RFC3339 time")
+		}
+		to = timestampKey(toTime.Unix()+1, 0)
+	}
+	pageSize := defaultStatementPageSize
+	if len(args) > 3 && len(args[3]) > 0 {
+		pageSize, err = strconv.Atoi(args[3])
+		if err != nil || pageSize < 1 {
+			return shim.Error("Invalid pageSize " + args[3] + ", expecting a positive integer")
+		}
+	}
+	// the bookmark is "timestamp~txID~leg" of the first entry of the next page
+	startAttributes := []string{account, from}
+	if len(args) > 4 && len(args[4]) > 0 {
+		startAttributes = append([]string{account}, strings.Split(args[4], "~")...)
+		if len(startAttributes) != 4 {
+			return shim.Error("Invalid bookmark " + args[4])
+		}
+	}
+
+	startKey, err := stub.CreateCompositeKey(statementObjectType, startAttributes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	endKey, err := stub.CreateCompositeKey(statementObjectType, []string{account, to})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// range scans do not take composite keys, so the index of the account is read and the bounds are applied while iterating
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(statementObjectType, []string{account})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	statement := Statement{account, []StatementEntry{}, ""}
+	// the balance after the last line of each asset, to derive the balance of the credits written as deltas
+	running := map[string]*big.Int{}
+	assets := map[string]*Asset{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if queryResponse.Key < startKey {
+			continue
+		}
+		if queryResponse.Key >= endKey {
+			break
+		}
+		_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if len(statement.Entries) == pageSize {
+			statement.Bookmark = strings.Join(attributes[1:], "~")
+			break
+		}
+
+		leg, _ := strconv.Atoi(attributes[3])
+		transferKey, err := stub.CreateCompositeKey(transferObjectType, []string{attributes[2], attributes[3]})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		transferBytes, err := stub.GetState(transferKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var transfer Transfer
+		err = json.Unmarshal(transferBytes, &transfer)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Transfer %s of the statement of %s is missing", attributes[2], account))
+		}
+
+		entry := StatementEntry{transfer.TxID, leg, transfer.Timestamp, "credit", transfer.From, transfer.Asset, transfer.Amount,
+			transfer.Memo, transfer.Initiator, transfer.ToBalanceAfter, false}
+		if transfer.From == account {
+			entry.Direction = "debit"
+			entry.Counterparty = transfer.To
+			entry.BalanceAfter = transfer.FromBalanceAfter
+		}
+
+		asset, ok := assets[transfer.Asset]
+		if !ok {
+			asset, err = getAsset(stub, transfer.Asset)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			assets[transfer.Asset] = asset
+		}
+		if len(entry.BalanceAfter) > 0 {
+			balance, err := parseAmount(asset, entry.BalanceAfter, true)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			running[asset.Code] = balance
+		} else if previous, ok := running[asset.Code]; ok {
+			amount, err := parseAmount(asset, entry.Amount, false)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			previous.Add(previous, amount)
+			entry.BalanceAfter = formatAmount(asset, previous)
+			entry.Derived = true
+		}
+		statement.Entries = append(statement.Entries, entry)
+	}
+
+	statementBytes, err := json.Marshal(statement)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(statementBytes)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func checkStatement(t *testing.T, payload []byte, balances ...string) Statement {
+	var statement Statement
+	err := json.Unmarshal(payload, &statement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statement.Entries) != len(balances) {
+		fmt.Println("Statement has", len(statement.Entries), "lines, expected", len(balances))
+		t.FailNow()
+	}
+	for i, entry := range statement.Entries {
+		if entry.BalanceAfter != balances[i] {
+			fmt.Println("Line", i, "has balance", entry.BalanceAfter, "expected", balances[i])
+			t.FailNow()
+		}
+	}
+	return statement
+}
+
+func TestExample02_Statement(t *testing.T) {
+	stub, cc := newTestStub()
+
+	checkInit(t, stub, cc, alice, "a", "100", "b", "0")
+	checkInvoke(t, stub, cc, alice, "invoke", "a", "b", "10", "first")
+	secondTime := time.Unix(cc.seconds+1, 0).UTC().Format(time.RFC3339)
+	checkInvoke(t, stub, cc, alice, "invoke", "a", "b", "20", "second")
+	checkInvoke(t, stub, cc, alice, "invoke", "a", "b", "30", "third")
+
+	statement := checkStatement(t, checkInvoke(t, stub, cc, alice, "statement", "a"), "90", "70", "40")
+	if statement.Entries[0].Direction != "debit" || statement.Entries[0].Counterparty != "b" || statement.Entries[0].Memo != "first" {
+		fmt.Println("Unexpected line", statement.Entries[0])
+		t.FailNow()
+	}
+	statement = checkStatement(t, checkInvoke(t, stub, cc, alice, "statement", "b"), "10", "30", "60")
+	if statement.Entries[2].Direction != "credit" || statement.Entries[2].Counterparty != "a" {
+		fmt.Println("Unexpected line", statement.Entries[2])
+		t.FailNow()
+	}
+
+	// The pages follow each other through the bookmark
+	statement = checkStatement(t, checkInvoke(t, stub, cc, alice, "statement", "a", "", "", "2"), "90", "70")
+	statement = checkStatement(t, checkInvoke(t, stub, cc, alice, "statement", "a", "", "", "2", statement.Bookmark), "40")
+	if len(statement.Bookmark) > 0 {
+		fmt.Println("The last page has the bookmark", statement.Bookmark)
+		t.FailNow()
+	}
+
+	// from and to are both included
+	checkStatement(t, checkInvoke(t, stub, cc, alice, "statement", "a", secondTime, secondTime), "70")
+	checkStatement(t, checkInvoke(t, stub, cc, alice, "statement", "a", secondTime), "70", "40")
+
+	var transfers []Transfer
+	err := json.Unmarshal(checkInvoke(t, stub, cc, alice, "transfer", statement.Entries[0].TxID), &transfers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 1 || transfers[0].Amount != "30" || transfers[0].ToBalanceAfter != "60" {
+		fmt.Println("Unexpected transfer", transfers)
+		t.FailNow()
+	}
+	checkInvokeFails(t, stub, cc, alice, "statement", "nobody")
+}
@@ -0,0 +1,111 @@
+#!/bin/bash
+
+# Measures the conflict rate of concurrent payments into one hot account of chaincode_example02,
+# first with the plain read-modify-write balance and then with the account in delta mode.
+# Run it in the cli container after ./scripts/script.sh, like: ./scripts/bench_hot_account.sh mychannel 50
+# The before and after lines are also appended to RESULTS_FILE together with the date and the block wait.
+# No measurements are included in the repository, RESULTS_FILE only exists once the script has been run against a network.
+
+echo
+echo "===================== Hot account benchmark ===================== "
+echo
+
+CHANNEL_NAME="$1"
+: ${CHANNEL_NAME:="mychannel"}
+PAYERS="$2"
+: ${PAYERS:="50"}
+: ${BLOCK_WAIT:="10"}
+: ${RESULTS_FILE:="./scripts/bench_hot_account.results"}
+ORDERER_CA=/opt/gopath/src/github.com/hyperledger/fabric/peer/crypto/ordererOrganizations/example.com/orderers/orderer.example.com/msp/cacerts/ca.example.com-cert.pem
+HOT_ACCOUNT=fee$$
+
+echo "Channel name : "$CHANNEL_NAME
+echo "Concurrent payers : "$PAYERS
+
+verifyResult () {
+	if [ $1 -ne 0 ] ; then
+		echo "!!!!!!!!!!!!!!! "$2" !!!!!!!!!!!!!!!!"
+		echo "================== ERROR !!! FAILED to execute the hot account benchmark =================="
+		echo
+		exit 1
+	fi
+}
+
+# The accounts are opened and funded by the identity which instantiated mycc, so it is the issuer of the default asset
+setGlobals () {
+	CORE_PEER_LOCALMSPID="Org1MSP"
+	CORE_PEER_TLS_ROOTCERT_FILE=/opt/gopath/src/github.com/hyperledger/fabric/peer/crypto/peerOrganizations/org1.example.com/peers/peer0.org1.example.com/tls/ca.crt
+	CORE_PEER_MSPCONFIGPATH=/opt/gopath/src/github.com/hyperledger/fabric/peer/crypto/peerOrganizations/org1.example.com/users/Admin@org1.example.com/msp
+	CORE_PEER_ADDRESS=peer0.org1.example.com:7051
+}
+
+invoke () {
+	if [ -z "$CORE_PEER_TLS_ENABLED" -o "$CORE_PEER_TLS_ENABLED" = "false" ]; then
+		peer chaincode invoke -o orderer.example.com:7050 -C $CHANNEL_NAME -n mycc -c "$1"
+	else
+		peer chaincode invoke -o orderer.example.com:7050 --tls $CORE_PEER_TLS_ENABLED --cafile $ORDERER_CA -C $CHANNEL_NAME -n mycc -c "$1"
+	fi
+}
+
+query () {
+	peer chaincode query -C $CHANNEL_NAME -n mycc -c "$1" 2>&1 | awk '/Query Result/ {print $NF}'
+}
+
+# Every payer pays 1 into the hot account at the same time, the payments which are not credited failed on MVCC
+payConcurrently () {
+	local round=$1
+	local before=$(query '{"Args":["query","'$HOT_ACCOUNT'"]}')
+	for i in $(seq 1 $PAYERS); do
+		invoke '{"Args":["invoke","'$HOT_ACCOUNT'p'$i'","'$HOT_ACCOUNT'","1","'$round'"]}' >&log_$i.txt &
+	done
+	wait
+	rm -f log_*.txt
+	sleep $BLOCK_WAIT
+	local after=$(query '{"Args":["query","'$HOT_ACCOUNT'"]}')
+	local credited=$((after - before))
+	local conflicts=$((PAYERS - credited))
+	local summary="$round: $credited of $PAYERS payments credited, $conflicts conflicts ($((conflicts * 100 / PAYERS))%)"
+	echo "===================== $summary ===================== "
+	echo
+	echo "$(date -u +%Y-%m-%dT%H:%M:%SZ) channel=$CHANNEL_NAME blockWait=${BLOCK_WAIT}s $summary" >>$RESULTS_FILE
+}
+
+setGlobals
+
+echo "Opening and funding $PAYERS payers and the hot account $HOT_ACCOUNT..."
+invoke '{"Args":["OpenAccount","'$HOT_ACCOUNT'"]}' >&log.txt
+verifyResult $? "Opening $HOT_ACCOUNT failed"
+for i in $(seq 1 $PAYERS); do
+	invoke '{"Args":["OpenAccount","'$HOT_ACCOUNT'p'$i'"]}' >&log.txt
+	verifyResult $? "Opening payer $i failed"
+done
+sleep $BLOCK_WAIT
+for i in $(seq 1 $PAYERS); do
+	invoke '{"Args":["mint","'$HOT_ACCOUNT'p'$i'","10","","bench"]}' >&log.txt
+	verifyResult $? "Funding payer $i failed"
+done
+sleep $BLOCK_WAIT
+
+echo "Paying into $HOT_ACCOUNT with read-modify-write balances..."
+payConcurrently "before"
+
+echo "Switching $HOT_ACCOUNT to delta mode..."
+invoke '{"Args":["setDeltaMode","'$HOT_ACCOUNT'","true"]}' >&log.txt
+verifyResult $? "Switching to delta mode failed"
+sleep $BLOCK_WAIT
+
+echo "Paying into $HOT_ACCOUNT with delta records..."
+payConcurrently "after"
+
+echo "Compacting $HOT_ACCOUNT..."
+invoke '{"Args":["compact","'$HOT_ACCOUNT'"]}' >&log.txt
+verifyResult $? "Compact failed"
+sleep $BLOCK_WAIT
+echo "Balance of $HOT_ACCOUNT: $(query '{"Args":["query","'$HOT_ACCOUNT'"]}')"
+echo "Supply check: $(query '{"Args":["checkSupply"]}')"
+
+echo
+echo "===================== Hot account benchmark completed ===================== "
+echo
+
+exit 0